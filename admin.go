@@ -0,0 +1,215 @@
+package gomodule
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var adminInstance *adminModule
+
+// HealthCheck is a named check contributed by a module. Check returns nil
+// when the module is healthy.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthChecker is implemented by modules which contribute checks to the
+// /healthz and /readyz endpoints of the admin module.
+type HealthChecker interface {
+	HealthChecks() []HealthCheck
+}
+
+type adminSettings struct {
+	Addr         string `mapstructure:"addr"`
+	CheckTimeout int    `mapstructure:"checkTimeout" default:"5" validate:"min=1"`
+}
+
+type adminModule struct {
+	DefaultModule
+	settings Settings[adminSettings]
+	ctx      context.Context
+	mtx      sync.Mutex
+	server   *http.Server
+	handlers map[string]http.Handler
+	logger   *logrus.Entry
+}
+
+type healthStatus struct {
+	Status  string                 `json:"status"`
+	Checks  map[string]string      `json:"checks,omitempty"`
+	Modules map[string]ModuleState `json:"modules,omitempty"`
+}
+
+func init() {
+	adminInstance = &adminModule{
+		handlers: make(map[string]http.Handler),
+		logger:   logrus.WithField("module", "admin"),
+	}
+}
+
+func AdminModule() IModule {
+	return adminInstance
+}
+
+func (a *adminModule) Logger() *logrus.Entry {
+	return a.logger
+}
+
+func (a *adminModule) Type() interface{} {
+	return a
+}
+
+func (a *adminModule) InitModule(ctx context.Context, m *Manager) (interface{}, error) {
+	a.Logger().Debug("init admin module")
+	a.ctx = ctx
+	a.Manager = m
+	return &a.settings, nil
+}
+
+func (a *adminModule) ConfigChanged() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if addr := a.settings.Load().Addr; a.server != nil && a.server.Addr != addr {
+		a.Logger().Warnf("admin addr changed to %s, restart to apply", addr)
+	}
+}
+
+// Handle registers an extra handler on the admin server. Handlers added after
+// the admin module starts running are not served.
+func (a *adminModule) Handle(pattern string, handler http.Handler) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.handlers[pattern] = handler
+}
+
+func (a *adminModule) ModuleRunE() error {
+	a.mtx.Lock()
+	addr := a.settings.Load().Addr
+	if addr == "" {
+		a.mtx.Unlock()
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", a.livez)
+	mux.HandleFunc("/readyz", a.readyz)
+	mux.HandleFunc("/healthz", a.healthz)
+	for pattern, handler := range a.handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	a.server = server
+	a.mtx.Unlock()
+
+	a.Logger().Infof("admin server listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (a *adminModule) ModuleStop(ctx context.Context) error {
+	a.mtx.Lock()
+	server := a.server
+	a.mtx.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	return server.Shutdown(ctx)
+}
+
+func (a *adminModule) checkTimeout() time.Duration {
+	return time.Duration(a.settings.Load().CheckTimeout) * time.Second
+}
+
+// runChecks runs the checks of every HealthChecker module, returning the
+// result of each check and whether all of them passed.
+func (a *adminModule) runChecks(ctx context.Context) (map[string]string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, a.checkTimeout())
+	defer cancel()
+
+	results := make(map[string]string)
+	ok := true
+	for _, hc := range GetAll[HealthChecker](a.Manager) {
+		for _, check := range hc.HealthChecks() {
+			if err := check.Check(ctx); err != nil {
+				results[check.Name] = err.Error()
+				ok = false
+			} else {
+				results[check.Name] = "ok"
+			}
+		}
+	}
+
+	return results, ok
+}
+
+func (a *adminModule) writeStatus(w http.ResponseWriter, ok bool, status *healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		status.Status = "ok"
+		w.WriteHeader(http.StatusOK)
+	} else {
+		status.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *adminModule) livez(w http.ResponseWriter, r *http.Request) {
+	a.Manager.lock.RLock()
+	ok := a.Manager.fatalErr == nil
+	a.Manager.lock.RUnlock()
+
+	a.writeStatus(w, ok, &healthStatus{})
+}
+
+func (a *adminModule) healthz(w http.ResponseWriter, r *http.Request) {
+	checks, ok := a.runChecks(r.Context())
+	modules := make(map[string]ModuleState)
+	for _, ms := range a.Manager.Modules() {
+		modules[ms.Name] = ms.State
+		if ms.State == StateFailed {
+			ok = false
+		}
+	}
+
+	a.writeStatus(w, ok, &healthStatus{Checks: checks, Modules: modules})
+}
+
+func (a *adminModule) readyz(w http.ResponseWriter, r *http.Request) {
+	checks, ok := a.runChecks(r.Context())
+	if a.ctx.Err() != nil {
+		ok = false
+	}
+
+	modules := make(map[string]ModuleState)
+	for _, ms := range a.Manager.Modules() {
+		modules[ms.Name] = ms.State
+		if ms.State != StateRunning && (ms.State != StateExited || ms.LastError != "") {
+			ok = false
+		}
+	}
+
+	a.writeStatus(w, ok, &healthStatus{Checks: checks, Modules: modules})
+}
+
+func HandleAdmin(pattern string, handler http.Handler) {
+	adminInstance.Handle(pattern, handler)
+}
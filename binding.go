@@ -0,0 +1,96 @@
+package gomodule
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Binding is the config section name bound to a value of type T by
+// RegisterConfig. The value is refilled, copy-on-write, on every reload.
+type Binding[T any] struct {
+	name        string
+	c           *configModule
+	settings    Settings[T]
+	unsubscribe func()
+	closeOnce   sync.Once
+}
+
+// RegisterConfig binds the config section name to a value of type T, a
+// struct with mapstructure, default and validate tags like module settings.
+// It can be called at any time: once the config is loaded the value is
+// filled from it before it returns, without a reload, and an invalid section
+// is an error. onChange, if
+// not nil, is called with the new value each time it is filled or changes,
+// not on reloads leaving it unchanged.
+func RegisterConfig[T any](name string, onChange func(T)) (*Binding[T], error) {
+	return registerConfig(&configInstance, name, onChange)
+}
+
+func registerConfig[T any](c *configModule, name string, onChange func(T)) (*Binding[T], error) {
+	b := &Binding[T]{name: name, c: c}
+	if onChange != nil {
+		b.unsubscribe = b.settings.Subscribe(func(_, new T) {
+			onChange(new)
+		})
+	}
+
+	c.mtx.Lock()
+	if _, ok := c.dynamicConf[name]; ok {
+		c.mtx.Unlock()
+		b.Close()
+		return nil, fmt.Errorf("config %s already registered", name)
+	}
+
+	// once the config is loaded only this section is staged from it, before
+	// that the first load fills the value
+	if c.config != nil {
+		staged, err := c.stageSettings(c.config, name, &b.settings)
+		if err != nil {
+			c.mtx.Unlock()
+			b.Close()
+			return nil, err
+		}
+
+		if fn := storeSettings(&b.settings, staged); fn != nil {
+			c.pending = append(c.pending, fn)
+		}
+	}
+
+	if c.dynamicConf == nil {
+		c.dynamicConf = make(map[string]interface{})
+	}
+	c.dynamicConf[name] = &b.settings
+	c.mtx.Unlock()
+
+	c.deliver()
+
+	return b, nil
+}
+
+// Name returns the config section of the binding.
+func (b *Binding[T]) Name() string {
+	return b.name
+}
+
+// Load returns the current value.
+func (b *Binding[T]) Load() T {
+	return b.settings.Load()
+}
+
+// Close unbinds the section: the value is no longer refilled and onChange no
+// longer called.
+func (b *Binding[T]) Close() error {
+	b.closeOnce.Do(func() {
+		b.c.mtx.Lock()
+		if b.c.dynamicConf[b.name] == &b.settings {
+			delete(b.c.dynamicConf, b.name)
+		}
+		b.c.mtx.Unlock()
+
+		if b.unsubscribe != nil {
+			b.unsubscribe()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package gomodule
+
+import (
+	"testing"
+)
+
+func TestRegisterConfig(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: a\nother:\n  name: a\n")
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := registerConfig[testSettings](c, "other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := registerConfig[testSettings](c, "other", nil); err == nil {
+		t.Fatal("duplicate registration accepted")
+	}
+
+	var b *Binding[testSettings]
+	var got []string
+	noDeadlock(t, func() {
+		b, err = registerConfig(c, "tmod", func(s testSettings) {
+			got = append(got, s.Name)
+
+			// every section is swapped in before the callbacks run
+			if o := other.Load(); o.Name != s.Name || c.Viper().GetString("other.name") != s.Name {
+				t.Errorf("got %+v beside %+v", o, s)
+			}
+
+			if s.Name == "b" {
+				b.Close()
+			}
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Load().Name != "a" || b.Load().Port != 80 {
+		t.Fatalf("got %+v", b.Load())
+	}
+
+	for _, name := range []string{"b", "c"} {
+		writeTestConfig(t, c, "tmod:\n  name: "+name+"\nother:\n  name: "+name+"\n")
+		noDeadlock(t, func() {
+			if err := c.reload("test"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" || b.Load().Name != "b" || other.Load().Name != "c" {
+		t.Fatalf("got changes %v, settings %+v %+v", got, b.Load(), other.Load())
+	}
+
+	if _, err := registerConfig[testSettings](c, "tmod", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a registration stages only its section from the loaded config
+	writeTestConfig(t, c, "tmod:\n  name: d\nbad:\n  port: -1\n")
+	if err := c.reload("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := 0
+	c.m.Subscribe(func(e Event) {
+		if e.Phase == PhaseConfigReloaded {
+			reloads++
+		}
+	})
+
+	if _, err := registerConfig[testSettings](c, "bad", nil); err == nil {
+		t.Fatal("registered an invalid section")
+	}
+
+	good, err := registerConfig[testSettings](c, "good", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if good.Load().Port != 80 || reloads != 0 {
+		t.Fatalf("got %+v, %d reloads", good.Load(), reloads)
+	}
+}
@@ -0,0 +1,153 @@
+package gomodule
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configCache is the snapshot of the etcd, consul and remote config documents
+// last applied, written to --cfg.cache so the service can start while they
+// are unreachable. Documents are kept as fetched, encrypted values stay
+// encrypted.
+type configCache struct {
+	Time     time.Time    `json:"time"`
+	Checksum string       `json:"checksum"`
+	Sources  []cacheEntry `json:"sources"`
+}
+
+type cacheEntry struct {
+	Kind     string `json:"kind"`
+	Location string `json:"location"`
+	Type     string `json:"type"`
+	Data     []byte `json:"data"`
+}
+
+func cacheChecksum(entries []cacheEntry) (string, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// saveCache writes the documents of the etcd, consul and remote sources to
+// the cache file when they differ from the last written ones. Nothing is
+// written while a source still serves its cached document, so the cache keeps
+// the time it was fetched at and --cfg.cache.maxage holds across restarts.
+// The caller must hold c.mtx.
+func (c *configModule) saveCache() error {
+	if c.flags.CacheFile == "" {
+		return nil
+	}
+
+	for _, s := range c.kvSources {
+		if s.cached {
+			return nil
+		}
+	}
+
+	if c.remote != nil && c.remote.cached {
+		return nil
+	}
+
+	entries := make([]cacheEntry, 0, len(c.kvSources)+1)
+	for _, s := range c.kvSources {
+		raw := c.flags.Etcd
+		if s.provider == SourceConsul {
+			raw = c.flags.Consul
+		}
+		entries = append(entries, cacheEntry{Kind: s.provider, Location: redactURL(raw), Type: s.configType, Data: s.data})
+	}
+
+	if c.remote != nil {
+		entries = append(entries, cacheEntry{Kind: SourceRemote, Location: redactURL(c.remote.url), Type: c.remote.configType, Data: c.remote.data})
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	sum, err := cacheChecksum(entries)
+	if err != nil {
+		return err
+	}
+
+	if sum == c.cacheSum {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(&configCache{Time: time.Now(), Checksum: sum, Sources: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so a crash never leaves a half written cache
+	tmp, err := os.CreateTemp(filepath.Dir(c.flags.CacheFile), filepath.Base(c.flags.CacheFile)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.flags.CacheFile)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.cacheSum = sum
+
+	return nil
+}
+
+// cachedSource returns the cached document of a source, if the cache may be
+// used at all, is intact and is not older than --cfg.cache.maxage, and the
+// checksum of the cache.
+func (c *configModule) cachedSource(kind, location string) ([]byte, string, string, error) {
+	if c.flags.CacheFile == "" || !c.flags.CacheFallback {
+		return nil, "", "", fmt.Errorf("config cache disabled")
+	}
+
+	data, err := os.ReadFile(c.flags.CacheFile)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("read config cache error, %s", err)
+	}
+
+	var cache configCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, "", "", fmt.Errorf("parse config cache error, %s", err)
+	}
+
+	sum, err := cacheChecksum(cache.Sources)
+	if err != nil || sum != cache.Checksum {
+		return nil, "", "", fmt.Errorf("config cache %s checksum mismatch", c.flags.CacheFile)
+	}
+
+	age := time.Since(cache.Time)
+	if maxAge := time.Duration(c.flags.CacheMaxAge) * time.Second; maxAge > 0 && age > maxAge {
+		return nil, "", "", fmt.Errorf("config cache is stale, written %s ago", age.Round(time.Second))
+	}
+
+	location = redactURL(location)
+	for _, e := range cache.Sources {
+		if e.Kind == kind && e.Location == location {
+			c.Logger().Warnf("%s unavailable, use config cached %s ago", kind, age.Round(time.Second))
+			return e.Data, e.Type, sum, nil
+		}
+	}
+
+	return nil, "", "", fmt.Errorf("no %s config cached for %s", kind, location)
+}
@@ -0,0 +1,160 @@
+package gomodule
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTestCache writes a cache of the remote document written at time.
+func writeTestCache(t *testing.T, file, remote, doc string, written time.Time) {
+	t.Helper()
+
+	entries := []cacheEntry{{Kind: SourceRemote, Location: redactURL(remote), Type: "yaml", Data: []byte(doc)}}
+	sum, err := cacheChecksum(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&configCache{Time: written, Checksum: sum, Sources: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestCache(t *testing.T, file string) configCache {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cache configCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func TestCacheFallback(t *testing.T) {
+	var up int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Write([]byte("tmod:\n  name: cached\n"))
+	}))
+	defer srv.Close()
+
+	c := newTestConfig(t, "tmod:\n  name: local\n")
+	c.flags.RemoteFile = srv.URL + "/config?token=secret"
+	c.flags.RemoteFileInterval = 1
+	c.flags.CacheFile = filepath.Join(t.TempDir(), "cache.json")
+	c.flags.CacheFallback = true
+	c.flags.CacheMaxAge = 60
+
+	written := time.Now().Add(-50 * time.Second).UTC().Truncate(time.Second)
+	writeTestCache(t, c.flags.CacheFile, c.flags.RemoteFile, "tmod:\n  name: cached\n", written)
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.loadConfigFromRemoteFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// serving from the cache does not make it look fresh
+	if cache := readTestCache(t, c.flags.CacheFile); settings.Name != "cached" || !cache.Time.Equal(written) {
+		t.Fatalf("got settings %+v, cache written at %s, want %s", settings, cache.Time, written)
+	}
+
+	// once the remote api is back the cache is rewritten, with the same
+	// document
+	atomic.StoreInt32(&up, 1)
+	c.pollRemoteFile(c.remote)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mtx.Lock()
+		cached := c.remote.cached
+		c.mtx.Unlock()
+
+		if cache := readTestCache(t, c.flags.CacheFile); !cached && cache.Time.After(written) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("cache not refreshed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		age      time.Duration
+		maxAge   int
+		fallback bool
+		err      string
+	}{
+		{name: "fresh", age: 50 * time.Second, maxAge: 60, fallback: true},
+		{name: "any age", age: 24 * time.Hour, fallback: true},
+		{name: "stale", age: 70 * time.Second, maxAge: 60, fallback: true, err: "config cache is stale"},
+		{name: "disabled", age: time.Second, fallback: false, err: "config cache disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t, "")
+			c.flags.RemoteFile = srv.URL
+			c.flags.CacheFile = filepath.Join(t.TempDir(), "cache.json")
+			c.flags.CacheFallback = tt.fallback
+			c.flags.CacheMaxAge = tt.maxAge
+			writeTestCache(t, c.flags.CacheFile, srv.URL, "tmod:\n  name: cached\n", time.Now().Add(-tt.age))
+
+			err := c.loadConfigFromRemoteFile()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCacheChecksumMismatch(t *testing.T) {
+	c := newTestConfig(t, "")
+	c.flags.CacheFile = filepath.Join(t.TempDir(), "cache.json")
+	c.flags.CacheFallback = true
+	writeTestCache(t, c.flags.CacheFile, "http://remote", "a: 1\n", time.Now())
+
+	data, _ := os.ReadFile(c.flags.CacheFile)
+	tampered := strings.Replace(string(data), `"checksum":"`, `"checksum":"0`, 1)
+	if err := os.WriteFile(c.flags.CacheFile, []byte(tampered), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := c.cachedSource(SourceRemote, "http://remote"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("got error %v", err)
+	}
+}
@@ -4,16 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-	"github.com/gogf/gf/os/gfile"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,12 +19,31 @@ var configInstance configModule
 type ConfigSettings struct {
 }
 
+// configFlags fields with an env tag are read from <prefix>_<env tag> when
+// the flag named by the flag tag is not given on the command line.
 type configFlags struct {
-	LocalFile          string `env:"localcfg" flag:"localcfg"`
-	Consul             string `env:"consulcfg" flag:"consulcfg"`
-	Etcd               string `env:"etcdcfg"   flag:"etcdcfg"`
-	RemoteFile         string `env:"remotecfg" flag:"remotecfg"`
-	RemoteFileInterval int    `env:"remotecfginterval" flag:"remotecfginterval"`
+	LocalFiles         []string `env:"localcfg" flag:"cfg.local"`
+	Name               string   `env:"cfgname" flag:"cfg.name"`
+	SearchPaths        []string `env:"cfgpath" flag:"cfg.path"`
+	Profile            string   `env:"cfgprofile" flag:"cfg.profile"`
+	ConfDir            string   `env:"cfgdir" flag:"cfg.dir"`
+	Consul             string   `env:"consulcfg" flag:"cfg.consul"`
+	Etcd               string   `env:"etcdcfg"   flag:"cfg.etcd"`
+	RemoteFile         string   `env:"remotecfg" flag:"cfg.remote"`
+	RemoteFileInterval int      `env:"remotecfginterval" flag:"cfg.remote.interval"`
+	RemoteTimeout      int      `env:"remotecfgtimeout" flag:"cfg.remote.timeout"`
+	RemoteToken        string   `env:"remotecfgtoken" flag:"cfg.remote.token"`
+	RemoteUser         string   `env:"remotecfguser" flag:"cfg.remote.user"`
+	RemotePassword     string   `env:"remotecfgpassword" flag:"cfg.remote.password"`
+	RemoteCA           string   `env:"remotecfgca" flag:"cfg.remote.ca"`
+	RemoteCert         string   `env:"remotecfgcert" flag:"cfg.remote.cert"`
+	RemoteKey          string   `env:"remotecfgkey" flag:"cfg.remote.key"`
+	EnvPrefix          string   `flag:"cfg.env.prefix"`
+	KeyFile            string   `env:"cfgkey" flag:"cfg.key"`
+	CacheFile          string   `env:"cfgcache" flag:"cfg.cache"`
+	CacheFallback      bool     `flag:"cfg.cache.fallback"`
+	CacheMaxAge        int      `env:"cfgcachemaxage" flag:"cfg.cache.maxage"`
+	Set                []string `flag:"cfg.set"`
 }
 
 type configModule struct {
@@ -42,6 +56,14 @@ type configModule struct {
 	logger      *logrus.Entry
 	m           *Manager
 	dynamicConf map[string]interface{}
+	localFiles  []string
+	layers      []configLayer
+	kvSources   []*kvSource
+	remote      *httpSource
+	cacheSum    string
+	overrides   map[string]interface{}
+	pending     []func()
+	delivering  bool
 }
 
 func init() {
@@ -57,6 +79,9 @@ func (c *configModule) Logger() *logrus.Entry {
 }
 
 func (c *configModule) Viper() *viper.Viper {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
 	return c.config
 }
 
@@ -72,243 +97,493 @@ func (c *configModule) Type() interface{} {
 
 func (c *configModule) InitCommand() ([]*cobra.Command, error) {
 	c.Logger().Debug("init config module")
-	GetRootCmd().PersistentFlags().StringVarP(&c.flags.LocalFile, "cfg.local", "c", "", "Load config file")
+	GetRootCmd().PersistentFlags().StringArrayVarP(&c.flags.LocalFiles, "cfg.local", "c", nil, "Load config file, can be repeated, later files override earlier ones")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.Name, "cfg.name", defaultConfigName, "Name of the config file searched without --cfg.local, any supported extension")
+	GetRootCmd().PersistentFlags().StringArrayVar(&c.flags.SearchPaths, "cfg.path", nil, "Directory searched for the config file without --cfg.local, can be repeated, default . and ./config")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.Profile, "cfg.profile", "", "Merge config.<profile>.<ext> beside the first config file")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.ConfDir, "cfg.dir", "", "Merge every config file of this directory, default conf.d beside the first config file")
 	GetRootCmd().PersistentFlags().StringVar(&c.flags.Consul, "cfg.consul", "", "Load config file from consul")
 	GetRootCmd().PersistentFlags().StringVar(&c.flags.Etcd, "cfg.etcd", "", "Load config file from etcd")
 	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteFile, "cfg.remote", "", "Load config file from remote api")
 	GetRootCmd().PersistentFlags().IntVar(&c.flags.RemoteFileInterval, "cfg.remote.interval", 30, "Interval to reload config file from remote api")
-
-	return nil, nil
+	GetRootCmd().PersistentFlags().IntVar(&c.flags.RemoteTimeout, "cfg.remote.timeout", defaultRemoteTimeout, "Timeout in seconds of a request to the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteToken, "cfg.remote.token", "", "Bearer token sent to the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteUser, "cfg.remote.user", "", "Basic auth user of the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemotePassword, "cfg.remote.password", "", "Basic auth password of the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteCA, "cfg.remote.ca", "", "CA certificate file to verify the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteCert, "cfg.remote.cert", "", "Client certificate file for the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.RemoteKey, "cfg.remote.key", "", "Client key file for the remote api")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.EnvPrefix, "cfg.env.prefix", defaultEnvPrefix, "Prefix of environment variables overriding config, <PREFIX>_<MODULE>_<FIELD>")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.KeyFile, "cfg.key", "", "AES key file decrypting ENC[...] config values")
+	GetRootCmd().PersistentFlags().StringVar(&c.flags.CacheFile, "cfg.cache", "", "Cache file of the last applied etcd, consul and remote config, used when they are unreachable at startup")
+	GetRootCmd().PersistentFlags().BoolVar(&c.flags.CacheFallback, "cfg.cache.fallback", true, "Start from the config cache when etcd, consul or the remote api is unreachable")
+	GetRootCmd().PersistentFlags().IntVar(&c.flags.CacheMaxAge, "cfg.cache.maxage", 0, "Max age in seconds of a usable config cache, 0 for any age")
+	GetRootCmd().PersistentFlags().StringArrayVar(&c.flags.Set, "cfg.set", nil, "Override a config value, module.key=value, can be repeated")
+
+	return []*cobra.Command{c.configCommand()}, nil
 }
 
-func (c *configModule) loadConfigFromLocal() {
-	if c.flags.LocalFile != "" {
-		if !gfile.Exists(c.flags.LocalFile) {
-			panic(fmt.Errorf("config file not found: %s", c.flags.LocalFile))
-		} else {
-			c.Logger().Infof("config file: %s\n", c.flags.LocalFile)
-		}
+// loadSources resolves the local config files and fetches the etcd, consul
+// and remote config once, without watching them.
+func (c *configModule) loadSources() error {
+	if err := c.applyFlagEnv(); err != nil {
+		return err
+	}
 
-		c.config.AddConfigPath(gfile.Dir(c.flags.LocalFile))
-		c.config.SetConfigName(gfile.Basename(c.flags.LocalFile))
-		c.config.SetConfigType(gfile.Ext(c.flags.LocalFile)[1:])
-	} else {
-		c.Logger().Debug("default config file: config.yml (./config.yml or ./config/config.yml)")
+	files, err := c.resolveLocalFiles()
+	if err != nil {
+		return err
+	}
 
-		pwd, err := os.Getwd()
-		if err != nil {
-			panic(fmt.Errorf("get current work dir failed, %s", err))
-		}
+	c.mtx.Lock()
+	c.localFiles = files
+	c.mtx.Unlock()
 
-		c.config.AddConfigPath(pwd)
-		c.config.AddConfigPath(".")        // optionally look for config in the working directory
-		c.config.AddConfigPath("./config") // optionally look for config in the working directory
-		c.config.SetConfigName("config")   // name of config file (without extension)
-		c.config.SetConfigType("yml")      // REQUIRED if the config file does not have the extension in the name
+	if err := c.loadConfigFromKV(SourceEtcd, c.flags.Etcd); err != nil {
+		return err
 	}
 
-	err := c.config.ReadInConfig()
-	if err != nil {
-		panic(fmt.Errorf("fatal error config file, %s", err))
+	if err := c.loadConfigFromKV(SourceConsul, c.flags.Consul); err != nil {
+		return err
 	}
-	c.reloadSettings()
 
-	c.config.OnConfigChange(func(e fsnotify.Event) {
-		if err := c.config.ReadInConfig(); err != nil {
-			panic(fmt.Errorf("fatal error config file, %s", err))
-		}
+	return c.loadConfigFromRemoteFile()
+}
 
-		c.Logger().Debug("Config file changed:", e.Name)
-		c.reloadSettings()
+// loadConfigFromKV reads the config document stored under the key of an etcd
+// or consul url.
+func (c *configModule) loadConfigFromKV(provider, raw string) error {
+	if raw == "" {
+		return nil
+	}
 
-		c.m.configChanged()
-	})
-	c.config.WatchConfig()
-}
+	s, err := parseKVSource(provider, raw)
+	if err != nil {
+		return err
+	}
 
-func (c *configModule) loadConfigFromEtcd() {
-	if c.flags.Etcd != "" {
-		u, err := url.Parse(c.flags.Etcd)
-		if err != nil {
-			panic(fmt.Errorf("etcd url parse error, %s", err))
-		}
+	var cacheSum string
+	data, err := s.get(c.ctx)
+	if err != nil {
+		err = fmt.Errorf("load config from %s error, %s", provider, err)
+		c.Logger().Error(err)
 
-		addr := u.Scheme + "://" + u.Host
-		path := u.Path
-		query := u.Query()
+		cached, ty, sum, e := c.cachedSource(provider, raw)
+		if e != nil {
+			return fmt.Errorf("%s; %s", err, e)
+		}
 
-		fileType := ""
-		ty := query["type"]
-		if len(ty) > 0 {
-			fileType = ty[0]
+		data, cacheSum = cached, sum
+		s.cached = true
+		if s.configType == "" {
+			s.configType = ty
 		}
+	}
 
-		c.config.AddRemoteProvider("etcd", addr, path)
-		c.config.SetConfigType(fileType)
-		c.config.ReadRemoteConfig()
+	c.mtx.Lock()
+	s.data = data
+	if s.cached {
+		c.cacheSum = cacheSum
 	}
+	c.kvSources = append(c.kvSources, s)
+	c.mtx.Unlock()
+	c.Logger().Infof("config from %s: %s/%s", provider, s.endpoint, strings.TrimPrefix(s.key, "/"))
+
+	return nil
 }
 
-func (c *configModule) loadConfigFromConsul() {
-	if c.flags.Consul != "" {
-		u, err := url.Parse(c.flags.Consul)
-		if err != nil {
-			panic(fmt.Errorf("consul url parse error, %s", err))
+// watchKV watches the key of an etcd or consul source, reloading when its
+// value changes, or when it is first read after starting from the cache, so
+// the cache is refreshed.
+func (c *configModule) watchKV(s *kvSource) {
+	go s.watch(c.ctx, func(data []byte) {
+		c.mtx.Lock()
+		changed := s.cached || !bytes.Equal(s.data, data)
+		if s.cached {
+			s.cached = false
+			c.cacheSum = ""
 		}
+		s.data = data
+		c.mtx.Unlock()
 
-		addr := u.Scheme + "://" + u.Host
-		path := u.Path
-		query := u.Query()
-
-		fileType := ""
-		ty := query["type"]
-		if len(ty) > 0 {
-			fileType = ty[0]
+		if changed {
+			c.Logger().Debugf("config from %s changed", s.provider)
+			c.reload(s.provider)
 		}
+	}, func(err error) {
+		c.Logger().Errorf("watch config from %s error, %s", s.provider, err)
+	})
+}
 
-		c.config.AddRemoteProvider("consul", addr, path)
-		c.config.SetConfigType(fileType)
-		c.config.ReadRemoteConfig()
+// loadConfigFromRemoteFile downloads the remote config.
+func (c *configModule) loadConfigFromRemoteFile() error {
+	if c.flags.RemoteFile == "" {
+		return nil
 	}
-}
 
-func (c *configModule) getRemoteFileContent() ([]byte, string, error) {
-	resp, err := http.Get(c.flags.RemoteFile)
+	remote, err := newHTTPSource(&c.flags)
 	if err != nil {
-		// handle error
-		return nil, "", fmt.Errorf("get config error, %s", err)
+		return err
 	}
 
-	defer resp.Body.Close()
-
-	configData, err := io.ReadAll(resp.Body)
+	var cacheSum string
+	configData, ty, _, err := remote.fetch(c.ctx)
 	if err != nil {
-		// handle error
-		return nil, "", fmt.Errorf("read config error, %s", err)
-	}
-
-	ct := resp.Header.Get("Content-Type")
-
-	if idx := strings.Index(ct, ";"); idx > 0 {
-		ct = ct[:idx]
-	}
-
-	// "yaml", "yml", "json", "toml", "hcl", "tfvars", "ini", "prop", "props", "properties", "dotenv", "env"
-	if strings.Contains(ct, "yaml") || strings.Contains(ct, "yml") {
-		return configData, "yaml", nil
-	} else if strings.Contains(ct, "toml") {
-		return configData, "toml", nil
-	} else if strings.Contains(ct, "json") {
-		return configData, "json", nil
-	} else if strings.Contains(ct, "prop") {
-		return configData, "prop", nil
-	} else if strings.Contains(ct, "props") {
-		return configData, "props", nil
-	} else if strings.Contains(ct, "tfvars") {
-		return configData, "tfvars", nil
-	} else if strings.Contains(ct, "properties") {
-		return configData, "properties", nil
-	} else if strings.Contains(ct, "hcl") {
-		return configData, "hcl", nil
-	} else if strings.Contains(ct, "ini") {
-		return configData, "ini", nil
-	} else if strings.Contains(ct, "dotenv") {
-		return configData, "dotenv", nil
-	} else if strings.Contains(ct, "env") {
-		return configData, "env", nil
-	} else {
-		return nil, "", fmt.Errorf("unknown config type")
-	}
-}
+		c.Logger().Error(err)
 
-func (c *configModule) loadConfigFromRemoteFile() {
-	if c.flags.RemoteFile == "" {
-		return
-	}
+		cached, cachedType, sum, e := c.cachedSource(SourceRemote, c.flags.RemoteFile)
+		if e != nil {
+			return fmt.Errorf("%s; %s", err, e)
+		}
 
-	configData, ty, err := c.getRemoteFileContent()
-	if err != nil {
-		panic(fmt.Errorf("get config error, %s", err))
+		configData, ty, cacheSum = cached, cachedType, sum
+		remote.cached = true
 	}
 
-	c.config.SetConfigType(ty)
-
-	if e := c.config.ReadConfig(bytes.NewBuffer(configData)); e != nil {
-		// handle error
-		panic(fmt.Errorf("read config error, %s", e))
+	c.mtx.Lock()
+	remote.data = configData
+	remote.configType = ty
+	if remote.cached {
+		c.cacheSum = cacheSum
 	}
+	c.remote = remote
+	c.mtx.Unlock()
 
-	if e := c.reloadSettings(); e != nil {
-		panic(fmt.Errorf("reload settings error, %s", e))
+	return nil
+}
+
+// pollRemoteFile polls the remote config every --cfg.remote.interval
+// seconds, reloading only when the content changed or was served from the
+// cache until then.
+func (c *configModule) pollRemoteFile(remote *httpSource) {
+	interval := time.Duration(c.flags.RemoteFileInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
 	}
 
 	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				c.Logger().Error("loadConfigFromRemoteFile error:", err)
-			}
-		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
 		for {
 			select {
 			case <-c.ctx.Done():
 				return
-			case <-time.After(time.Duration(c.flags.RemoteFileInterval) * time.Second):
-				c.reloadSettings()
+			case <-ticker.C:
+				configData, ty, changed, err := remote.fetch(c.ctx)
+				if err != nil {
+					c.Logger().Error("poll remote config error, keep last config, ", err)
+					continue
+				}
+
+				c.mtx.Lock()
+				// the first fetch after starting from the cache refreshes it
+				if remote.cached {
+					remote.cached = false
+					c.cacheSum = ""
+					changed = true
+				}
+
+				if !changed {
+					c.mtx.Unlock()
+					continue
+				}
+
+				remote.data = configData
+				remote.configType = ty
+				c.mtx.Unlock()
+
+				c.Logger().Debug("remote config changed")
+				c.reload("remote")
 			}
 		}
 	}()
 }
 
 func (c *configModule) PreModuleRun() {
-	c.config = viper.New()
+	if e := c.loadSources(); e != nil {
+		panic(e)
+	}
+
+	if len(c.localFiles) > 0 {
+		c.Logger().Infof("config files: %s", strings.Join(c.localFiles, ", "))
+		if e := c.watchLocalFiles(); e != nil {
+			c.Logger().Error("watch config file error, ", e)
+		}
+	}
+
+	for _, s := range c.kvSources {
+		c.watchKV(s)
+	}
+
+	if c.remote != nil {
+		c.pollRemoteFile(c.remote)
+	}
+
+	if sources, e := c.Sources(); e == nil {
+		c.Logger().Infof("config sources: %v", sources)
+	}
+
+	// there is no previous config to fall back to at startup
+	if e := c.reload("startup"); e != nil {
+		panic(fmt.Errorf("reload settings error, %s", e))
+	}
+}
+
+// buildConfig reads every source and deep merges them, in the order of
+// Sources, into a fresh viper instance, so a source which fails to load or
+// parse leaves the current config untouched. The caller must hold c.mtx.
+func (c *configModule) buildConfig() (*viper.Viper, []configLayer, error) {
+	layers, err := c.readLayers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := viper.New()
+	for _, l := range layers {
+		if len(l.values) == 0 {
+			continue
+		}
+
+		if err := v.MergeConfigMap(l.values); err != nil {
+			return nil, nil, fmt.Errorf("merge config from %s error, %s", l.source, err)
+		}
+	}
+
+	return v, layers, nil
+}
+
+// reload reloads every module's settings and emits a PhaseConfigReloaded
+// event naming the source which triggered it.
+func (c *configModule) reload(source string) error {
+	start := time.Now()
+	err := c.reloadSettings()
+	if err != nil {
+		c.Logger().Errorf("reload config from %s failed, keep last good config, %s", source, err)
+	}
+	c.m.emit(Event{Module: "config", Phase: PhaseConfigReloaded, Source: source, Time: start, Duration: time.Since(start), Err: err})
+
+	return err
+}
+
+type stagedSettings struct {
+	module   *ModuleInfo
+	name     string
+	settings interface{}
+	value    reflect.Value
+}
 
-	if c.flags.RemoteFile != "" {
-		c.loadConfigFromRemoteFile()
-	} else {
-		c.loadConfigFromLocal()
-		c.loadConfigFromEtcd()
-		c.loadConfigFromConsul()
+type settingsChange struct {
+	module *ModuleInfo
+	old    interface{}
+	new    interface{}
+	paths  []string
+}
+
+// diffSettings returns the config paths of the fields which differ between
+// old and new, recursing into nested structs.
+func diffSettings(path []string, old, new reflect.Value) []string {
+	if old.Kind() == reflect.Struct && old.Type() != timeType {
+		paths := make([]string, 0)
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			key, ok := settingsKey(f)
+			if !ok {
+				continue
+			}
+
+			fieldPath := path
+			if key != "" {
+				fieldPath = append(append([]string(nil), path...), key)
+			}
+			paths = append(paths, diffSettings(fieldPath, old.Field(i), new.Field(i))...)
+		}
+		return paths
+	}
+
+	if reflect.DeepEqual(old.Interface(), new.Interface()) {
+		return nil
 	}
+
+	return []string{strings.Join(path, ".")}
 }
 
+// reloadSettings rebuilds the config and stages every module's settings from
+// it. Nothing is applied unless every module's settings unmarshal and
+// validate, in which case the config and all settings are swapped in before
+// the Settings subscribers and ConfigChanged are called. They run without
+// c.mtx held, so they may read the config or set overrides.
 func (c *configModule) reloadSettings() error {
+	if err := c.swapSettings(); err != nil {
+		return err
+	}
+
+	c.deliver()
+
+	return nil
+}
+
+// deliver runs the pending notifications in the order their reloads swapped
+// the config in, one batch at a time. A reload made while another goroutine
+// or a callback is delivering leaves its notifications to that delivery.
+func (c *configModule) deliver() {
+	c.mtx.Lock()
+	if c.delivering {
+		c.mtx.Unlock()
+		return
+	}
+
+	c.delivering = true
+	defer func() {
+		c.delivering = false
+		c.mtx.Unlock()
+	}()
+
+	for len(c.pending) > 0 {
+		fn := c.pending[0]
+		c.pending = c.pending[1:]
+
+		c.mtx.Unlock()
+		func() {
+			defer c.mtx.Lock()
+			fn()
+		}()
+	}
+}
+
+// swapSettings builds and stages the config under c.mtx and swaps it in,
+// queueing the notifications of the Settings subscribers and the modules.
+func (c *configModule) swapSettings() error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	v, layers, err := c.buildConfig()
+	if err != nil {
+		return err
+	}
+
+	staged, err := c.stageAll(v)
+	if err != nil {
+		return err
+	}
+
+	// every module is notified of the first load, afterwards only the
+	// modules whose settings changed
+	initial := c.config == nil
+	changes := make([]*settingsChange, 0)
+	changed := make(map[*ModuleInfo]*settingsChange)
+	notify := make([]func(), 0)
+	for _, ss := range staged {
+		current := currentSettings(ss.settings)
+		paths := diffSettings(nil, current, ss.value.Elem())
+		if !initial && len(paths) == 0 {
+			continue
+		}
+
+		if ss.module != nil {
+			changed[ss.module] = &settingsChange{
+				module: ss.module,
+				old:    current.Interface(),
+				new:    ss.value.Elem().Interface(),
+				paths:  paths,
+			}
+		}
+
+		if fn := storeSettings(ss.settings, ss.value); fn != nil {
+			notify = append(notify, fn)
+		}
+	}
+	c.config = v
+	c.layers = layers
+
+	if err := c.saveCache(); err != nil {
+		c.Logger().Error("write config cache error, ", err)
+	}
+
+	for _, mi := range c.m.modules {
+		if ch, ok := changed[mi]; ok {
+			changes = append(changes, ch)
+		} else if initial {
+			changes = append(changes, &settingsChange{module: mi})
+		}
+	}
+
+	c.pending = append(c.pending, func() {
+		for _, fn := range notify {
+			fn()
+		}
+		c.m.configChanged(changes)
+	})
+
+	return nil
+}
+
+// stageAll stages the settings of every module and registered config from v.
+// The caller must hold c.mtx.
+func (c *configModule) stageAll(v *viper.Viper) ([]stagedSettings, error) {
 	c.Logger().Debug("reload settings, modules:", len(c.m.modules))
+	staged := make([]stagedSettings, 0, len(c.m.modules)+len(c.dynamicConf))
+	errs := make([]string, 0)
 	for _, mi := range c.m.modules {
 		if mi.settings == nil {
 			continue
 		}
 		c.Logger().Debug("reload settings:", mi.name)
-		if err := c.config.UnmarshalKey(mi.name, mi.settings); err != nil {
-			return fmt.Errorf("unmarshal config error, %s", err)
+		value, err := c.stageSettings(v, mi.name, mi.settings)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
 		}
+		staged = append(staged, stagedSettings{module: mi, name: mi.name, settings: mi.settings, value: value})
 	}
 
 	for name, val := range c.dynamicConf {
-		if err := c.config.UnmarshalKey(name, val); err != nil {
-			return fmt.Errorf("unmarshal config error, %s", err)
+		value, err := c.stageSettings(v, name, val)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
 		}
+		staged = append(staged, stagedSettings{name: name, settings: val, value: value})
 	}
 
-	c.m.configChanged()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("reload settings failed, %s", strings.Join(errs, "; "))
+	}
 
-	return nil
+	return staged, nil
 }
 
-func (c *configModule) RegisterConfig(name string, val interface{}) {
-	c.mtx.Lock()
-	defer c.mtx.Unlock()
+// stageSettings unmarshals the name section of the config into a fresh value
+// of the type settings points to. Default tags are applied before
+// unmarshalling and validate tags checked after.
+func (c *configModule) stageSettings(v *viper.Viper, name string, settings interface{}) (reflect.Value, error) {
+	if _, ok := settings.(settingsHolder); !ok && reflect.TypeOf(settings).Kind() != reflect.Ptr {
+		return reflect.Value{}, fmt.Errorf("module[%s] settings must be pointer", name)
+	}
 
-	if c.dynamicConf == nil {
-		c.dynamicConf = make(map[string]interface{})
+	staged := reflect.New(settingsType(settings))
+	section, _ := v.Get(name).(map[string]interface{})
+	if err := applyDefaults(name, staged, section); err != nil {
+		return reflect.Value{}, err
 	}
 
-	c.dynamicConf[name] = val
-}
+	if err := v.UnmarshalKey(name, staged.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("module[%s] unmarshal config error, %s", name, err)
+	}
+
+	errs, err := validateSettings(name, nil, staged)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if len(errs) > 0 {
+		return reflect.Value{}, &ValidationError{Module: name, Errors: errs}
+	}
 
-func RegisterConfig(name string, val interface{}) {
-	configInstance.RegisterConfig(name, val)
+	return staged, nil
 }
@@ -0,0 +1,145 @@
+package gomodule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testSettings struct {
+	Name  string   `mapstructure:"name"`
+	Port  int      `mapstructure:"port" default:"80" validate:"min=1"`
+	Hosts []string `mapstructure:"hosts"`
+}
+
+type testModule struct {
+	DefaultModule
+	configChanged func()
+}
+
+func (t *testModule) Type() interface{} {
+	return t
+}
+
+func (t *testModule) ConfigChanged() {
+	if t.configChanged != nil {
+		t.configChanged()
+	}
+}
+
+// newTestConfig returns a config module reading the yaml config, with no
+// module registered.
+func newTestConfig(t *testing.T, config string) *configModule {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &configModule{
+		flags:      configFlags{EnvPrefix: "GOMODULE_TEST"},
+		ctx:        ctx,
+		logger:     logrus.WithField("module", "config"),
+		m:          NewManager(),
+		localFiles: []string{file},
+	}
+}
+
+// writeTestConfig replaces the config file of c.
+func writeTestConfig(t *testing.T, c *configModule, config string) {
+	t.Helper()
+
+	if err := os.WriteFile(c.localFiles[0], []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// noDeadlock fails the test when fn does not return in time.
+func noDeadlock(t *testing.T, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock")
+	}
+}
+
+func TestConfigChangedReadsConfig(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: a\n")
+
+	var settings testSettings
+	var name string
+	mod := &testModule{configChanged: func() {
+		name = c.Viper().GetString("tmod.name")
+		if _, err := c.Sources(); err != nil {
+			t.Error(err)
+		}
+		if _, err := c.EffectiveConfig(); err != nil {
+			t.Error(err)
+		}
+	}}
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: mod, settings: &settings, name: "tmod"})
+
+	events := 0
+	c.m.Subscribe(func(e Event) {
+		if e.Phase == PhaseConfigChanged {
+			c.Viper()
+			events++
+		}
+	})
+
+	noDeadlock(t, func() {
+		if err := c.reload("startup"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if name != "a" || settings.Name != "a" || settings.Port != 80 {
+		t.Fatalf("got name %q, settings %+v", name, settings)
+	}
+
+	writeTestConfig(t, c, "tmod:\n  name: b\n")
+	noDeadlock(t, func() {
+		if err := c.reload("test"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if name != "b" || events != 2 {
+		t.Fatalf("got name %q, %d events", name, events)
+	}
+}
+
+func TestReloadKeepsLastGoodConfig(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  port: 8080\n")
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, c, "tmod:\n  port: -1\n")
+	if err := c.reload("test"); err == nil {
+		t.Fatal("invalid config applied")
+	}
+
+	if settings.Port != 8080 || c.Viper().GetInt("tmod.port") != 8080 {
+		t.Fatalf("got settings %+v", settings)
+	}
+}
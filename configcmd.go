@@ -0,0 +1,198 @@
+package gomodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// configCommand groups the subcommands inspecting the config. They run
+// without starting any module.
+func (c *configModule) configCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect the config",
+	}
+
+	cmd.AddCommand(c.sourcesCommand())
+	cmd.AddCommand(c.showCommand())
+	cmd.AddCommand(c.encryptCommand())
+	cmd.AddCommand(c.schemaCommand())
+	cmd.AddCommand(c.validateCommand())
+
+	return cmd
+}
+
+// resolveFlags applies the config flag environment variables and resolves
+// the local config files, as PreModuleRun does, for the config subcommands.
+func (c *configModule) resolveFlags() error {
+	if err := c.applyFlagEnv(); err != nil {
+		return err
+	}
+
+	files, err := c.resolveLocalFiles()
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.localFiles = files
+	c.mtx.Unlock()
+
+	return nil
+}
+
+func (c *configModule) sourcesCommand() *cobra.Command {
+	asJSON := false
+	cmd := &cobra.Command{
+		Use:   "sources",
+		Short: "list config sources in merge order, later sources override earlier ones",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := c.resolveFlags(); err != nil {
+				return err
+			}
+
+			sources, err := c.Sources()
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(sources)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ORDER\tKIND\tLOCATION")
+			for i, s := range sources {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", i+1, s.Kind, s.Location)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print sources as json")
+
+	return cmd
+}
+
+func (c *configModule) showCommand() *cobra.Command {
+	asJSON := false
+	cmd := &cobra.Command{
+		Use:   "show [module...]",
+		Short: "print the effective config of every module, or the given ones, and the source of each value",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := c.stagedConfig()
+			if err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				filtered := make([]ConfigValue, 0, len(values))
+				for _, cv := range values {
+					for _, name := range args {
+						if strings.EqualFold(cv.Module, name) {
+							filtered = append(filtered, cv)
+						}
+					}
+				}
+				values = filtered
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(values)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "MODULE\tKEY\tVALUE\tSOURCE")
+			for _, cv := range values {
+				source := cv.Source.Kind
+				if cv.Source.Location != "" {
+					source = cv.Source.String()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", cv.Module, cv.Key, cv.Value, source)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print values as json")
+
+	return cmd
+}
+
+func (c *configModule) encryptCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt [value]",
+		Short: "encrypt a value with the --cfg.key file into an ENC[...] config value, read from stdin without an argument",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.applyFlagEnv(); err != nil {
+				return err
+			}
+
+			key, err := c.loadSecretKey()
+			if err != nil {
+				return err
+			}
+
+			var plain string
+			if len(args) > 0 {
+				plain = args[0]
+			} else {
+				data, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return err
+				}
+				plain = strings.TrimRight(string(data), "\r\n")
+			}
+
+			value, err := encryptValue(key, plain)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), value)
+			return nil
+		},
+	}
+}
+
+func (c *configModule) schemaCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "print the JSON Schema of the config document",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(c.ConfigSchema())
+		},
+	}
+}
+
+func (c *configModule) validateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "load the config from every source and check it against the module settings, e.g. config validate --cfg.local=config.yml",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			unknown, err := c.validateConfig()
+			for _, key := range unknown {
+				fmt.Fprintf(cmd.OutOrStdout(), "warning: unknown config key %s\n", key)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "config ok")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package gomodule
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// ConfigDecoder parses a config document into a config tree.
+type ConfigDecoder func(data []byte) (map[string]interface{}, error)
+
+// decoders maps media types to config formats, and formats registered by
+// the application to their decoders. Formats without a decoder are parsed by
+// viper.
+var decoders = struct {
+	sync.RWMutex
+	formats    map[string]ConfigDecoder
+	mediaTypes map[string]string
+}{
+	formats: make(map[string]ConfigDecoder),
+	mediaTypes: map[string]string{
+		"application/yaml":         "yaml",
+		"application/x-yaml":       "yaml",
+		"text/yaml":                "yaml",
+		"text/x-yaml":              "yaml",
+		"application/json":         "json",
+		"text/json":                "json",
+		"application/toml":         "toml",
+		"application/x-toml":       "toml",
+		"text/toml":                "toml",
+		"application/hcl":          "hcl",
+		"text/x-hcl":               "hcl",
+		"text/x-java-properties":   "properties",
+		"text/x-properties":        "properties",
+		"text/x-ini":               "ini",
+		"application/x-ini":        "ini",
+		"application/x-dotenv":     "dotenv",
+		"text/x-dotenv":            "dotenv",
+		"application/x-tfvars":     "tfvars",
+		"application/x-hcl-tfvars": "tfvars",
+	},
+}
+
+// RegisterConfigDecoder registers decode for the config format, which can
+// then be named by a ?type= query parameter, a file extension or one of
+// mediaTypes served by the remote api. A built-in format can be overridden
+// and more media types can be mapped to it.
+func RegisterConfigDecoder(format string, decode ConfigDecoder, mediaTypes ...string) {
+	decoders.Lock()
+	defer decoders.Unlock()
+
+	format = strings.ToLower(format)
+	if decode != nil {
+		decoders.formats[format] = decode
+	}
+
+	for _, mt := range mediaTypes {
+		decoders.mediaTypes[strings.ToLower(mt)] = format
+	}
+}
+
+func configDecoder(format string) ConfigDecoder {
+	decoders.RLock()
+	defer decoders.RUnlock()
+
+	return decoders.formats[strings.ToLower(format)]
+}
+
+// mediaTypeFormat returns the config format of a Content-Type, or "" when
+// it is unknown. Structured syntax suffixes like +json and +yaml are
+// recognized.
+func mediaTypeFormat(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	decoders.RLock()
+	defer decoders.RUnlock()
+
+	if format, ok := decoders.mediaTypes[mt]; ok {
+		return format
+	}
+
+	if idx := strings.LastIndex(mt, "+"); idx >= 0 {
+		switch mt[idx+1:] {
+		case "json":
+			return "json"
+		case "yaml":
+			return "yaml"
+		case "toml":
+			return "toml"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,120 @@
+package gomodule
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMediaTypeFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		format      string
+	}{
+		{"application/yaml", "yaml"},
+		{"application/x-yaml; charset=utf-8", "yaml"},
+		{"text/yaml", "yaml"},
+		{"Application/JSON", "json"},
+		{"application/json; charset=utf-8", "json"},
+		{"application/toml", "toml"},
+		{"text/x-hcl", "hcl"},
+		{"text/x-java-properties", "properties"},
+		{"text/x-ini", "ini"},
+		{"text/x-dotenv", "dotenv"},
+		{"application/x-tfvars", "tfvars"},
+		{"application/vnd.app.config+json", "json"},
+		{"application/vnd.app.config+yaml", "yaml"},
+		{"application/vnd.app.config+toml", "toml"},
+		{"application/vnd.app.config+xml", ""},
+		{"text/plain", ""},
+		{"application/octet-stream", ""},
+		{"", ""},
+		{"not a media type;;", ""},
+	}
+
+	for _, tt := range tests {
+		if format := mediaTypeFormat(tt.contentType); format != tt.format {
+			t.Errorf("%q: got %q, want %q", tt.contentType, format, tt.format)
+		}
+	}
+}
+
+func TestSniffConfigType(t *testing.T) {
+	tests := []struct {
+		data   string
+		format string
+	}{
+		{`{"a": {"b": 1}}`, "json"},
+		{"[a]\nb = 1\n", "toml"},
+		{"a:\n  b: 1\n", "yaml"},
+		{"a = 1\nb = \"x\"\n", "toml"},
+		{"", ""},
+		{"just some text", ""},
+	}
+
+	for _, tt := range tests {
+		if format := sniffConfigType([]byte(tt.data)); format != tt.format {
+			t.Errorf("%q: got %q, want %q", tt.data, format, tt.format)
+		}
+	}
+}
+
+func TestRegisterConfigDecoder(t *testing.T) {
+	t.Cleanup(func() {
+		decoders.Lock()
+		delete(decoders.formats, "testkv")
+		delete(decoders.mediaTypes, "application/x-testkv")
+		decoders.Unlock()
+	})
+
+	// testkv is one key=value pair per line
+	RegisterConfigDecoder("TestKV", func(data []byte) (map[string]interface{}, error) {
+		values := make(map[string]interface{})
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid line %q", line)
+			}
+			values[kv[0]] = kv[1]
+		}
+		return values, nil
+	}, "application/x-testkv")
+
+	if format := mediaTypeFormat("application/x-testkv"); format != "testkv" {
+		t.Fatalf("got format %q", format)
+	}
+
+	if !isConfigExt(".testkv") || isConfigExt(".unknown") {
+		t.Fatal("registered extension not recognized")
+	}
+
+	values, err := readConfigData([]byte("a=1\nb=2\n"), "testkv")
+	if err != nil || !reflect.DeepEqual(values, map[string]interface{}{"a": "1", "b": "2"}) {
+		t.Fatalf("got %v, %v", values, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "config.testkv")
+	if err := os.WriteFile(file, []byte("c=3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err = readConfigFile(file)
+	if err != nil || !reflect.DeepEqual(values, map[string]interface{}{"c": "3"}) {
+		t.Fatalf("got %v, %v", values, err)
+	}
+
+	// more media types can be mapped to a format later
+	RegisterConfigDecoder("testkv", nil, "text/x-testkv")
+	defer func() {
+		decoders.Lock()
+		delete(decoders.mediaTypes, "text/x-testkv")
+		decoders.Unlock()
+	}()
+
+	if format := mediaTypeFormat("text/x-testkv"); format != "testkv" {
+		t.Fatalf("got format %q", format)
+	}
+}
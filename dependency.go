@@ -0,0 +1,140 @@
+package gomodule
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dependent is implemented by modules which must be initialized after other
+// modules. Each dependency is either a module name, or a type value following
+// the Feature convention, e.g. (*feature.Feature)(nil) for an interface or
+// (*SimpleModule)(nil) for a concrete module.
+type Dependent interface {
+	Dependencies() []interface{}
+}
+
+func dependencyName(dep interface{}) string {
+	if name, ok := dep.(string); ok {
+		return name
+	}
+
+	return reflect.TypeOf(dep).String()
+}
+
+func matchDependency(f Feature, name string, dep interface{}) bool {
+	if s, ok := dep.(string); ok {
+		return s == name
+	}
+
+	t := reflect.TypeOf(dep)
+	if t == nil {
+		return false
+	}
+
+	if reflect.TypeOf(f.Type()) == t || reflect.TypeOf(f) == t {
+		return true
+	}
+
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		return reflect.TypeOf(f).Implements(t.Elem())
+	}
+
+	return false
+}
+
+// sortModules orders m.modules so that every module comes after the modules
+// it depends on. Modules without dependencies keep their registration order.
+func (m *Manager) sortModules() error {
+	deps := make([][]int, len(m.modules))
+	missing := make([]string, 0)
+
+	for i, mi := range m.modules {
+		d, ok := mi.module.(Dependent)
+		if !ok {
+			continue
+		}
+
+		for _, dep := range d.Dependencies() {
+			if dep == nil {
+				return fmt.Errorf("module[%s] has a nil dependency", mi.name)
+			}
+
+			found := false
+			for j, dmi := range m.modules {
+				if j != i && matchDependency(dmi.module, dmi.name, dep) {
+					deps[i] = append(deps[i], j)
+					found = true
+				}
+			}
+
+			if !found {
+				for _, f := range m.features {
+					if matchDependency(f, "", dep) {
+						found = true
+						break
+					}
+				}
+			}
+
+			if !found {
+				missing = append(missing, fmt.Sprintf("%s -> %s", mi.name, dependencyName(dep)))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing module dependencies: %s", strings.Join(missing, ", "))
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(m.modules))
+	stack := make([]int, 0)
+	sorted := make([]*ModuleInfo, 0, len(m.modules))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			chain := make([]string, 0)
+			for k := len(stack) - 1; k >= 0; k-- {
+				chain = append([]string{m.modules[stack[k]].name}, chain...)
+				if stack[k] == i {
+					break
+				}
+			}
+			chain = append(chain, m.modules[i].name)
+			return fmt.Errorf("module dependency cycle: %s", strings.Join(chain, " -> "))
+		}
+
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range deps[i] {
+			if e := visit(j); e != nil {
+				return e
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		sorted = append(sorted, m.modules[i])
+
+		return nil
+	}
+
+	for i := range m.modules {
+		if e := visit(i); e != nil {
+			return e
+		}
+	}
+
+	m.modules = sorted
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package gomodule
+
+import (
+	"strings"
+	"testing"
+)
+
+type depModule struct {
+	DefaultModule
+	deps []interface{}
+}
+
+func (d *depModule) Dependencies() []interface{} {
+	return d.deps
+}
+
+type depA struct{ depModule }
+
+func (d *depA) Type() interface{} {
+	return d
+}
+
+type depB struct{ depModule }
+
+func (d *depB) Type() interface{} {
+	return d
+}
+
+func (d *depB) Greet() {}
+
+type greeter interface {
+	Greet()
+}
+
+// depFeature is a feature added with AddFeature rather than a module.
+type depFeature struct{}
+
+func (f *depFeature) Type() interface{} {
+	return f
+}
+
+func TestSortModules(t *testing.T) {
+	tests := []struct {
+		name     string
+		modules  []*ModuleInfo
+		features []Feature
+		order    string
+		err      string
+	}{
+		{
+			name: "registration order",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{}},
+				{name: "b", module: &depB{}},
+			},
+			order: "a,b",
+		},
+		{
+			name: "by name",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{"b"}}}},
+				{name: "b", module: &depB{}},
+			},
+			order: "b,a",
+		},
+		{
+			name: "by type",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{(*depB)(nil)}}}},
+				{name: "b", module: &depB{}},
+			},
+			order: "b,a",
+		},
+		{
+			name: "by interface",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{(*greeter)(nil)}}}},
+				{name: "b", module: &depB{}},
+			},
+			order: "b,a",
+		},
+		{
+			name: "by feature",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{(*depFeature)(nil)}}}},
+			},
+			features: []Feature{&depFeature{}},
+			order:    "a",
+		},
+		{
+			name: "chain",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{"b"}}}},
+				{name: "b", module: &depB{depModule{deps: []interface{}{"c"}}}},
+				{name: "c", module: &depA{}},
+			},
+			order: "c,b,a",
+		},
+		{
+			name: "missing",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{"x", (*greeter)(nil)}}}},
+			},
+			err: "missing module dependencies: a -> x, a -> *gomodule.greeter",
+		},
+		{
+			name: "nil",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{nil}}}},
+			},
+			err: "module[a] has a nil dependency",
+		},
+		{
+			name: "cycle",
+			modules: []*ModuleInfo{
+				{name: "a", module: &depA{depModule{deps: []interface{}{"b"}}}},
+				{name: "b", module: &depB{depModule{deps: []interface{}{"c"}}}},
+				{name: "c", module: &depA{depModule{deps: []interface{}{"b"}}}},
+			},
+			err: "module dependency cycle: b -> c -> b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			m.modules = tt.modules
+			m.features = tt.features
+
+			err := m.sortModules()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := make([]string, 0, len(m.modules))
+			for _, mi := range m.modules {
+				names = append(names, mi.name)
+			}
+
+			if order := strings.Join(names, ","); order != tt.order {
+				t.Fatalf("got order %s, want %s", order, tt.order)
+			}
+		})
+	}
+}
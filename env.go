@@ -0,0 +1,178 @@
+package gomodule
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultEnvPrefix = "GOMODULE"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// envName builds an environment variable name from its parts, upper casing
+// them and replacing every character which is not a letter or digit with '_'.
+func envName(parts ...string) string {
+	name := strings.ToUpper(strings.Join(parts, "_"))
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// settingsKey returns the config key of a settings struct field, following
+// the mapstructure tag like viper does.
+func settingsKey(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("mapstructure")
+	if idx := strings.Index(tag, ","); idx >= 0 {
+		if strings.Contains(tag[idx:], "squash") {
+			return "", true
+		}
+		tag = tag[:idx]
+	}
+
+	if tag == "-" {
+		return "", false
+	}
+
+	if tag == "" {
+		return f.Name, true
+	}
+
+	return tag, true
+}
+
+// envOverlay looks up <prefix>_<name>_<field> for every field of the settings
+// struct t, recursing into nested structs, and returns the values found as a
+// config tree rooted at the module's settings.
+func envOverlay(prefix string, path []string, t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	tree := make(map[string]interface{})
+	if t.Kind() != reflect.Struct {
+		return tree
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key, ok := settingsKey(f)
+		if !ok {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if key == "" {
+			for k, v := range envOverlay(prefix, path, ft) {
+				tree[k] = v
+			}
+			continue
+		}
+
+		fieldPath := append(append([]string(nil), path...), key)
+		if ft.Kind() == reflect.Struct && ft != timeType {
+			if sub := envOverlay(prefix, fieldPath, ft); len(sub) > 0 {
+				tree[key] = sub
+			}
+			continue
+		}
+
+		if v, ok := os.LookupEnv(envName(append([]string{prefix}, fieldPath...)...)); ok {
+			tree[key] = v
+		}
+	}
+
+	return tree
+}
+
+// applyFlagEnv fills every config flag which was not given on the command
+// line from the <prefix>_<env tag> environment variable. List flags take a
+// comma separated value.
+func (c *configModule) applyFlagEnv() error {
+	v := reflect.ValueOf(&c.flags).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		env := f.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+
+		if flag := GetRootCmd().PersistentFlags().Lookup(f.Tag.Get("flag")); flag != nil && flag.Changed {
+			continue
+		}
+
+		val, ok := os.LookupEnv(envName(c.flags.EnvPrefix, env))
+		if !ok {
+			continue
+		}
+
+		switch f.Type.Kind() {
+		case reflect.String:
+			v.Field(i).SetString(val)
+		case reflect.Slice:
+			v.Field(i).Set(reflect.ValueOf(strings.Split(val, ",")))
+		case reflect.Int:
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("env %s parse error, %s", envName(c.flags.EnvPrefix, env), err)
+			}
+			v.Field(i).SetInt(int64(n))
+		}
+	}
+
+	return nil
+}
+
+// setOverlay turns the key=value pairs of --cfg.set into a config tree.
+func setOverlay(pairs []string) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	for _, pair := range pairs {
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid --cfg.set value %q, want key=value", pair)
+		}
+
+		setPath(tree, strings.Split(pair[:idx], "."), pair[idx+1:])
+	}
+
+	return tree, nil
+}
+
+// envLayer returns the <prefix>_<module>_<field> environment variables set
+// for every module's settings as a config tree. The caller must hold c.mtx.
+func (c *configModule) envLayer() map[string]interface{} {
+	overlay := make(map[string]interface{})
+	for _, mi := range c.m.modules {
+		if mi.settings == nil {
+			continue
+		}
+
+		if tree := envOverlay(c.flags.EnvPrefix, []string{mi.name}, settingsType(mi.settings)); len(tree) > 0 {
+			overlay[mi.name] = tree
+		}
+	}
+
+	for name, val := range c.dynamicConf {
+		if tree := envOverlay(c.flags.EnvPrefix, []string{name}, settingsType(val)); len(tree) > 0 {
+			overlay[name] = tree
+		}
+	}
+
+	return overlay
+}
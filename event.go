@@ -0,0 +1,114 @@
+package gomodule
+
+import (
+	"fmt"
+	"time"
+)
+
+type Phase int
+
+const (
+	PhaseInitialized Phase = iota
+	PhaseCommandsRegistered
+	PhasePreRun
+	PhaseRunning
+	PhaseConfigChanged
+	PhaseFailed
+	PhaseRestarting
+	PhaseExited
+	PhaseStopping
+	PhaseStopped
+	PhaseConfigReloaded
+)
+
+var phaseNames = map[Phase]string{
+	PhaseInitialized:        "initialized",
+	PhaseCommandsRegistered: "commands-registered",
+	PhasePreRun:             "pre-run",
+	PhaseRunning:            "running",
+	PhaseConfigChanged:      "config-changed",
+	PhaseFailed:             "failed",
+	PhaseRestarting:         "restarting",
+	PhaseExited:             "exited",
+	PhaseStopping:           "stopping",
+	PhaseStopped:            "stopped",
+	PhaseConfigReloaded:     "config-reloaded",
+}
+
+func (p Phase) String() string {
+	if name, ok := phaseNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// Event describes a lifecycle transition of a module. Duration is the time
+// spent in the callback which completed the phase, and Err is set when that
+// callback failed. Source names the config source for PhaseConfigReloaded
+// events.
+type Event struct {
+	Module   string
+	Phase    Phase
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+	Source   string
+}
+
+type subscriber struct {
+	handler func(Event)
+}
+
+// Subscribe registers handler to receive every lifecycle event, in the order
+// they happen. Handlers run synchronously on the goroutine driving the
+// transition and must not block. The returned function removes the handler.
+func (m *Manager) Subscribe(handler func(Event)) func() {
+	s := &subscriber{handler: handler}
+
+	m.eventLock.Lock()
+	m.subscribers = append(m.subscribers, s)
+	m.eventLock.Unlock()
+
+	return func() {
+		m.eventLock.Lock()
+		defer m.eventLock.Unlock()
+
+		for i, sub := range m.subscribers {
+			if sub == s {
+				m.subscribers = append(m.subscribers[:i:i], m.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (m *Manager) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	m.track(e)
+
+	m.eventLock.RLock()
+	subscribers := m.subscribers
+	m.eventLock.RUnlock()
+
+	for _, s := range subscribers {
+		m.dispatch(s, e)
+	}
+}
+
+func (m *Manager) dispatch(s *subscriber, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.logger.Errorf("event handler panic on %s of module[%s], %v", e.Phase, e.Module, r)
+		}
+	}()
+
+	s.handler(e)
+}
+
+func Subscribe(handler func(Event)) func() {
+	return defaultmanager.Subscribe(handler)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"sync"
 	"time"
 
 	"github.com/let-light/gomodule"
@@ -16,26 +15,23 @@ type MainFlags struct {
 }
 
 type Settings struct {
-	Test string `mapstructure:"test"`
+	Test string `mapstructure:"test" validate:"required"`
 }
 
 type SimpleModule struct {
 	gomodule.DefaultModule
-	flags       *MainFlags
-	presettings Settings
-	settings    *Settings
-	ctx         context.Context
-	logger      *logrus.Entry
-	mutex       sync.RWMutex
+	flags    *MainFlags
+	settings gomodule.Settings[Settings]
+	ctx      context.Context
+	logger   *logrus.Entry
 }
 
 var instance *SimpleModule
 
 func init() {
 	instance = &SimpleModule{
-		flags:    &MainFlags{},
-		settings: &Settings{},
-		logger:   logrus.WithField("module", "simple"),
+		flags:  &MainFlags{},
+		logger: logrus.WithField("module", "simple"),
 	}
 }
 
@@ -68,50 +64,43 @@ func (s *SimpleModule) InitModule(ctx context.Context, m *gomodule.Manager) (int
 	s.ctx = ctx
 	s.Manager = m
 
-	m.RequireFeatures(func(cc feature_configcenter.Feature, ss *SimpleModule) {
+	if e := m.RequireFeatures(func(cc feature_configcenter.Feature, ss *SimpleModule) {
 		cc.HelloWorld()
 		ss.Logger().Info("require modules done")
-	})
+	}); e != nil {
+		return nil, e
+	}
 	s.Logger().Info("init simple module")
-	return &s.presettings, nil
+	return &s.settings, nil
 }
 
 func (s *SimpleModule) ConfigChanged() {
 	s.Logger().Info("simple module config changed")
-	if err := s.settings == nil; err {
-		s.settings = &Settings{}
-	}
-
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	*s.settings = s.presettings
-
-	s.Logger().Info("simple module config changed done")
 }
 
-func (s *SimpleModule) SafeSettings() Settings {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	return *s.settings
+func (s *SimpleModule) SettingsChanged(old, new interface{}, paths []string) {
+	s.Logger().Infof("settings changed, fields: %v, old: %+v, new: %+v", paths, old, new)
 }
 
 func (s *SimpleModule) ModuleRun() {
 	s.Logger().Info("simple module run ...")
 
-	s.Logger().Info("settings: ", s.settings.Test)
+	s.Logger().Info("settings: ", s.settings.Load().Test)
 	for {
 		select {
 		case <-s.ctx.Done():
 			s.Logger().Info("all module done")
 			return
 		case <-time.After(time.Second):
-			s.Logger().Infof("tick, settings: %+v...", s.SafeSettings())
+			s.Logger().Infof("tick, settings: %+v...", s.settings.Load())
 		}
 	}
 }
 
+func (s *SimpleModule) Dependencies() []interface{} {
+	return []interface{}{(*feature_configcenter.Feature)(nil)}
+}
+
 func (s *SimpleModule) Type() interface{} {
 	return (**SimpleModule)(nil)
 }
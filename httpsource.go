@@ -0,0 +1,162 @@
+package gomodule
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const defaultRemoteTimeout = 10
+
+// httpSource is a config document served over http(s) by --cfg.remote. It
+// is fetched with conditional requests, so an unchanged document costs a 304
+// and does not trigger a reload.
+type httpSource struct {
+	url          string
+	queryType    string
+	token        string
+	user         string
+	password     string
+	timeout      time.Duration
+	client       *http.Client
+	etag         string
+	lastModified string
+	data         []byte
+	configType   string
+	// cached is set while data comes from the config cache
+	cached bool
+}
+
+// newHTTPSource builds the remote source from the --cfg.remote.* flags.
+func newHTTPSource(flags *configFlags) (*httpSource, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if flags.RemoteCA != "" || flags.RemoteCert != "" || flags.RemoteKey != "" {
+		tlsConfig := &tls.Config{}
+
+		if flags.RemoteCA != "" {
+			pem, err := os.ReadFile(flags.RemoteCA)
+			if err != nil {
+				return nil, fmt.Errorf("read remote ca file error, %s", err)
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in remote ca file %s", flags.RemoteCA)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if flags.RemoteCert != "" || flags.RemoteKey != "" {
+			cert, err := tls.LoadX509KeyPair(flags.RemoteCert, flags.RemoteKey)
+			if err != nil {
+				return nil, fmt.Errorf("load remote client cert error, %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	timeout := flags.RemoteTimeout
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+
+	u, err := url.Parse(flags.RemoteFile)
+	if err != nil {
+		return nil, fmt.Errorf("remote url parse error, %s", err)
+	}
+
+	return &httpSource{
+		url:       flags.RemoteFile,
+		queryType: u.Query().Get("type"),
+		token:     flags.RemoteToken,
+		user:      flags.RemoteUser,
+		password:  flags.RemotePassword,
+		timeout:   time.Duration(timeout) * time.Second,
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// fetch downloads the document unless it is unchanged since the last fetch,
+// reporting whether the content changed. A failed fetch keeps the previous
+// content.
+func (s *httpSource) fetch(ctx context.Context) ([]byte, string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("get config error, %s", err)
+	}
+
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("get config error, %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return s.data, s.configType, false, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("read config error, %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", false, fmt.Errorf("get config status %s, %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	ty, err := s.documentType(resp.Header.Get("Content-Type"), data)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	changed := s.data == nil || ty != s.configType || !bytes.Equal(data, s.data)
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+
+	return data, ty, changed, nil
+}
+
+// documentType returns the format of the document: the ?type= query
+// parameter of the url, else the format registered for its Content-Type,
+// else the format sniffed from its content.
+func (s *httpSource) documentType(contentType string, data []byte) (string, error) {
+	if s.queryType != "" {
+		return s.queryType, nil
+	}
+
+	if ty := mediaTypeFormat(contentType); ty != "" {
+		return ty, nil
+	}
+
+	if ty := sniffConfigType(data); ty != "" {
+		return ty, nil
+	}
+
+	return "", fmt.Errorf("unknown config type of content type %q", contentType)
+}
@@ -0,0 +1,95 @@
+package gomodule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSourceConditionalFetch(t *testing.T) {
+	body, version := "a: 1\n", "v1"
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+
+		etag := `"` + version + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Sat, 17 Oct 2026 10:00:00 GMT")
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	s, err := newHTTPSource(&configFlags{RemoteFile: srv.URL, RemoteToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(wantChanged bool, want string) {
+		t.Helper()
+
+		data, ty, changed, err := s.fetch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if changed != wantChanged || string(data) != want || ty != "yaml" {
+			t.Fatalf("got %q of type %s, changed %v", data, ty, changed)
+		}
+		s.data, s.configType = data, ty
+	}
+
+	fetch(true, "a: 1\n")
+	fetch(false, "a: 1\n")
+	body, version = "a: 2\n", "v2"
+	fetch(true, "a: 2\n")
+
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+
+	if h := requests[0].Header; h.Get("If-None-Match") != "" || h.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("first request headers %v", h)
+	}
+
+	if h := requests[1].Header; h.Get("If-None-Match") != `"v1"` || h.Get("If-Modified-Since") == "" {
+		t.Fatalf("conditional request headers %v", h)
+	}
+}
+
+func TestHTTPSourceBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "u" || password != "p" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"a": 1}`))
+	}))
+	defer srv.Close()
+
+	s, err := newHTTPSource(&configFlags{RemoteFile: srv.URL, RemoteUser: "u", RemotePassword: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ty, _, err := s.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no content type, the format is sniffed
+	if string(data) != `{"a": 1}` || ty != "json" {
+		t.Fatalf("got %q of type %s", data, ty)
+	}
+
+	s.user = "x"
+	if _, _, _, err := s.fetch(context.Background()); err == nil {
+		t.Fatal("unauthorized fetch succeeded")
+	}
+}
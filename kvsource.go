@@ -0,0 +1,346 @@
+package gomodule
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kvRequestTimeout = 10 * time.Second
+	kvWatchBackoff   = time.Second
+	kvWatchMaxWait   = 30 * time.Second
+	consulBlockWait  = "5m"
+)
+
+// kvSource is a config document stored as the value of a single key in etcd
+// or consul, addressed by a URL like
+// http://127.0.0.1:2379/config/app.yml?type=yaml. The type defaults to the
+// key's extension, else it is sniffed from the value. A consul ACL token can
+// be given with ?token=.
+type kvSource struct {
+	provider   string
+	endpoint   string
+	key        string
+	configType string
+	token      string
+	client     *http.Client
+	data       []byte
+	// cached is set while data comes from the config cache
+	cached bool
+}
+
+func parseKVSource(provider, raw string) (*kvSource, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s url parse error, %s", provider, err)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s url %q has no host", provider, raw)
+	}
+
+	scheme := u.Scheme
+	if scheme != "https" {
+		scheme = "http"
+	}
+
+	s := &kvSource{
+		provider:   provider,
+		endpoint:   scheme + "://" + u.Host,
+		key:        u.Path,
+		configType: u.Query().Get("type"),
+		token:      u.Query().Get("token"),
+		client:     http.DefaultClient,
+	}
+
+	if provider == "consul" {
+		s.key = strings.TrimPrefix(s.key, "/")
+	}
+
+	if s.key == "" {
+		return nil, fmt.Errorf("%s url %q has no key", provider, raw)
+	}
+
+	if ext := filepath.Ext(s.key); s.configType == "" && isConfigExt(ext) {
+		s.configType = strings.TrimPrefix(ext, ".")
+	}
+
+	return s, nil
+}
+
+// get fetches the current value of the key.
+func (s *kvSource) get(ctx context.Context) ([]byte, error) {
+	if s.provider == "consul" {
+		data, _, err := s.consulGet(ctx, 0)
+		return data, err
+	}
+
+	data, _, err := s.etcdGet(ctx)
+	return data, err
+}
+
+// watch calls onChange with the value of the key every time a watch is
+// (re)established and every time the key changes, until ctx is done, so a
+// change made while disconnected is not lost. Broken connections are retried
+// with a growing backoff.
+func (s *kvSource) watch(ctx context.Context, onChange func([]byte), onError func(error)) {
+	watch := s.etcdWatch
+	if s.provider == "consul" {
+		watch = s.consulWatch
+	}
+
+	backoff := kvWatchBackoff
+	for {
+		start := time.Now()
+		err := watch(ctx, onChange)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			onError(err)
+		}
+
+		if time.Since(start) > kvWatchMaxWait {
+			backoff = kvWatchBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > kvWatchMaxWait {
+			backoff = kvWatchMaxWait
+		}
+	}
+}
+
+type etcdKeyValue struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader     `json:"header"`
+	Kvs    []etcdKeyValue `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Header   etcdHeader `json:"header"`
+		Created  bool       `json:"created"`
+		Canceled bool       `json:"canceled"`
+		Events   []struct {
+			Type string       `json:"type"`
+			Kv   etcdKeyValue `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// etcdPost posts a JSON request to the etcd v3 gRPC gateway.
+func (s *kvSource) etcdPost(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd %s status %s, %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return resp, nil
+}
+
+// etcdGet returns the value of the key and the store revision it was read at.
+func (s *kvSource) etcdGet(ctx context.Context) ([]byte, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, kvRequestTimeout)
+	defer cancel()
+
+	resp, err := s.etcdPost(ctx, "/v3/kv/range", map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(s.key)),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var r etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, 0, fmt.Errorf("etcd range response decode error, %s", err)
+	}
+
+	rev, _ := strconv.ParseInt(r.Header.Revision, 10, 64)
+	if len(r.Kvs) == 0 {
+		return nil, rev, fmt.Errorf("etcd key %s not found", s.key)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(r.Kvs[0].Value)
+	if err != nil {
+		return nil, rev, fmt.Errorf("etcd value decode error, %s", err)
+	}
+
+	return data, rev, nil
+}
+
+// etcdWatch reads the key, then streams watch events for it from the
+// following revision, so no change between the read and the watch is lost.
+func (s *kvSource) etcdWatch(ctx context.Context, onChange func([]byte)) error {
+	data, rev, err := s.etcdGet(ctx)
+	if err != nil {
+		return err
+	}
+	onChange(data)
+
+	resp, err := s.etcdPost(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            base64.StdEncoding.EncodeToString([]byte(s.key)),
+			"start_revision": strconv.FormatInt(rev+1, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var w etcdWatchResponse
+		if err := dec.Decode(&w); err != nil {
+			return fmt.Errorf("etcd watch stream error, %s", err)
+		}
+
+		if w.Error != nil {
+			return fmt.Errorf("etcd watch error, %s", w.Error.Message)
+		}
+
+		if w.Result.Canceled {
+			return fmt.Errorf("etcd watch canceled")
+		}
+
+		for _, e := range w.Result.Events {
+			if e.Type == "DELETE" {
+				return fmt.Errorf("etcd key %s deleted", s.key)
+			}
+
+			data, err := base64.StdEncoding.DecodeString(e.Kv.Value)
+			if err != nil {
+				return fmt.Errorf("etcd value decode error, %s", err)
+			}
+			onChange(data)
+		}
+	}
+}
+
+// consulGet reads the key, blocking until its index moves past index when
+// index is non zero, and returns the raw value and the new index.
+func (s *kvSource) consulGet(ctx context.Context, index uint64) ([]byte, uint64, error) {
+	q := url.Values{}
+	q.Set("raw", "true")
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", consulBlockWait)
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, kvRequestTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/v1/kv/"+s.key+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, 0, fmt.Errorf("consul key %s not found", s.key)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul read error, %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul kv status %s, %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul index header invalid, %s", err)
+	}
+
+	return data, newIndex, nil
+}
+
+// consulWatch polls the key with blocking queries, calling onChange only
+// when the index moves forward.
+func (s *kvSource) consulWatch(ctx context.Context, onChange func([]byte)) error {
+	data, index, err := s.consulGet(ctx, 0)
+	if err != nil {
+		return err
+	}
+	onChange(data)
+
+	for {
+		data, newIndex, err := s.consulGet(ctx, index)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case newIndex < index:
+			// the index went backwards, e.g. after a snapshot restore
+			onChange(data)
+		case newIndex > index:
+			onChange(data)
+		}
+
+		if newIndex > 0 {
+			index = newIndex
+		} else {
+			index = 1
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package gomodule
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// fakeEtcd serves the etcd v3 gateway range api for the key /app.yml at
+// revision 5, and streams the watch messages to every watch.
+func fakeEtcd(t *testing.T, value string, watch []string) (*httptest.Server, *kvSource) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/kv/range", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["key"] != b64("/app.yml") {
+			t.Errorf("range request %v, %v", req, err)
+		}
+
+		kvs := ""
+		if value != "" {
+			kvs = fmt.Sprintf(`,"kvs":[{"key":%q,"value":%q,"mod_revision":"5"}]`, b64("/app.yml"), b64(value))
+		}
+		fmt.Fprintf(w, `{"header":{"revision":"5"}%s}`, kvs)
+	})
+	mux.HandleFunc("/v3/watch", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CreateRequest struct {
+				Key           string `json:"key"`
+				StartRevision string `json:"start_revision"`
+			} `json:"create_request"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		if req.CreateRequest.Key != b64("/app.yml") || req.CreateRequest.StartRevision != "6" {
+			t.Errorf("watch request %+v", req)
+		}
+
+		for _, msg := range watch {
+			fmt.Fprintln(w, msg)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	s, err := parseKVSource(SourceEtcd, srv.URL+"/app.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, s
+}
+
+func etcdEvent(ty, value string) string {
+	return fmt.Sprintf(`{"result":{"header":{"revision":"6"},"events":[{"type":%q,"kv":{"key":%q,"value":%q}}]}}`, ty, b64("/app.yml"), b64(value))
+}
+
+func TestParseKVSource(t *testing.T) {
+	s, err := parseKVSource(SourceConsul, "http://127.0.0.1:8500/config/app?type=json&token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.endpoint != "http://127.0.0.1:8500" || s.key != "config/app" || s.configType != "json" || s.token != "secret" {
+		t.Fatalf("got %+v", s)
+	}
+
+	s, err = parseKVSource(SourceEtcd, "http://127.0.0.1:2379/config/app.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.key != "/config/app.yml" || s.configType != "yml" {
+		t.Fatalf("got %+v", s)
+	}
+
+	if _, err := parseKVSource(SourceEtcd, "http://127.0.0.1:2379"); err == nil {
+		t.Fatal("url without key accepted")
+	}
+}
+
+func TestEtcdGet(t *testing.T) {
+	_, s := fakeEtcd(t, "a: 1", nil)
+
+	data, rev, err := s.etcdGet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "a: 1" || rev != 5 {
+		t.Fatalf("got %q at revision %d", data, rev)
+	}
+
+	_, s = fakeEtcd(t, "", nil)
+	if _, _, err := s.etcdGet(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestEtcdWatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		watch []string
+		err   string
+		got   []string
+	}{
+		{
+			name:  "delete",
+			watch: []string{`{"result":{"header":{"revision":"5"},"created":true}}`, etcdEvent("PUT", "a: 2"), etcdEvent("DELETE", "")},
+			err:   "deleted",
+			got:   []string{"a: 1", "a: 2"},
+		},
+		{
+			name:  "canceled",
+			watch: []string{`{"result":{"header":{"revision":"5"},"created":true}}`, `{"result":{"canceled":true}}`},
+			err:   "canceled",
+			got:   []string{"a: 1"},
+		},
+		{
+			name:  "error",
+			watch: []string{`{"error":{"message":"etcdserver: mvcc: required revision has been compacted"}}`},
+			err:   "compacted",
+			got:   []string{"a: 1"},
+		},
+		{
+			name:  "closed",
+			watch: []string{`{"result":{"header":{"revision":"5"},"created":true}}`, etcdEvent("PUT", "a: 3")},
+			err:   "stream error",
+			got:   []string{"a: 1", "a: 3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, s := fakeEtcd(t, "a: 1", tt.watch)
+
+			var got []string
+			err := s.etcdWatch(context.Background(), func(data []byte) {
+				got = append(got, string(data))
+			})
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+
+			if strings.Join(got, "|") != strings.Join(tt.got, "|") {
+				t.Fatalf("got changes %q, want %q", got, tt.got)
+			}
+		})
+	}
+}
+
+// fakeConsul serves the key config/app from a list of responses, one per
+// request; a response of value "" is a 404.
+type fakeConsul struct {
+	mtx       sync.Mutex
+	responses []consulResponse
+	indexes   []string
+	tokens    []string
+}
+
+type consulResponse struct {
+	index int
+	value string
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.URL.Path != "/v1/kv/config/app" || r.URL.Query().Get("raw") == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	f.indexes = append(f.indexes, r.URL.Query().Get("index"))
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	if len(f.responses) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	w.Header().Set("X-Consul-Index", fmt.Sprint(resp.index))
+	if resp.value == "" {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, resp.value)
+}
+
+func newFakeConsul(t *testing.T, responses ...consulResponse) (*fakeConsul, *kvSource) {
+	t.Helper()
+
+	f := &fakeConsul{responses: responses}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	s, err := parseKVSource(SourceConsul, srv.URL+"/config/app?type=yaml&token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, s
+}
+
+func TestConsulGet(t *testing.T) {
+	f, s := newFakeConsul(t, consulResponse{index: 7, value: "a: 1"})
+
+	data, index, err := s.consulGet(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "a: 1" || index != 7 || f.tokens[0] != "secret" || f.indexes[0] != "" {
+		t.Fatalf("got %q at index %d, requests %v %v", data, index, f.indexes, f.tokens)
+	}
+
+	if _, _, err := s.consulGet(context.Background(), 7); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got error %v", err)
+	}
+
+	if f.indexes[1] != "7" {
+		t.Fatalf("blocking query index %q", f.indexes[1])
+	}
+}
+
+func TestConsulWatch(t *testing.T) {
+	f, s := newFakeConsul(t,
+		consulResponse{index: 10, value: "a: 1"},
+		// the blocking query timed out, the value is unchanged
+		consulResponse{index: 10, value: "a: 1"},
+		consulResponse{index: 12, value: "a: 2"},
+		// the index went backwards, e.g. after a snapshot restore
+		consulResponse{index: 3, value: "a: 3"},
+		consulResponse{index: 0, value: ""},
+	)
+
+	var got []string
+	err := s.consulWatch(context.Background(), func(data []byte) {
+		got = append(got, string(data))
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got error %v", err)
+	}
+
+	if strings.Join(got, "|") != "a: 1|a: 2|a: 3" {
+		t.Fatalf("got changes %q", got)
+	}
+
+	if strings.Join(f.indexes, ",") != ",10,10,12,3" {
+		t.Fatalf("got indexes %q", f.indexes)
+	}
+}
+
+func TestKVWatchRetries(t *testing.T) {
+	f, s := newFakeConsul(t,
+		consulResponse{index: 10, value: "a: 1"},
+		consulResponse{index: 0, value: ""},
+		consulResponse{index: 11, value: "a: 2"},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	var errs int
+	s.watch(ctx, func(data []byte) {
+		got = append(got, string(data))
+		if len(got) == 2 {
+			cancel()
+		}
+	}, func(err error) {
+		errs++
+	})
+
+	if strings.Join(got, "|") != "a: 1|a: 2" || errs != 1 {
+		t.Fatalf("got changes %q, %d errors, requests %v", got, errs, f.indexes)
+	}
+}
@@ -16,26 +16,25 @@ type Fields logrus.Fields
 var loggerInstance *loggerModule
 
 type loggerSettings struct {
-	Formatter      string `mapstructure:"formatter"`
+	Formatter      string `mapstructure:"formatter" default:"json" validate:"oneof=text|json"`
 	Format         string `mapstructure:"format"`
 	File           string `mapstructure:"file"`
 	Console        bool   `mapstructure:"console"`
 	Color          bool   `mapstructure:"color"`
-	Level          string `mapstructure:"level"`
+	Level          string `mapstructure:"level" default:"info" validate:"oneof=panic|fatal|error|warn|warning|info|debug|trace"`
 	ReportCaller   bool   `mapstructure:"reportCaller"`
 	FilePattern    string `mapstructure:"filePattern"`
-	MaxAge         int    `mapstructure:"maxAge"`
-	RotationTime   int    `mapstructure:"rotationTime"`
-	RotationCount  int    `mapstructure:"rotationCount"`
-	RotationSize   int    `mapstructure:"rotationSize"`
+	MaxAge         int    `mapstructure:"maxAge" validate:"min=0"`
+	RotationTime   int    `mapstructure:"rotationTime" default:"24" validate:"min=1"`
+	RotationCount  int    `mapstructure:"rotationCount" validate:"min=0"`
+	RotationSize   int    `mapstructure:"rotationSize" default:"100" validate:"min=1"`
 	DisableSorting bool   `mapstructure:"disableSorting"`
 }
 
 type loggerModule struct {
 	DefaultModule
-	presettings loggerSettings
-	settings    *loggerSettings
-	logger      *logrus.Entry
+	settings Settings[loggerSettings]
+	logger   *logrus.Entry
 }
 
 func init() {
@@ -54,86 +53,63 @@ func (l *loggerModule) Logger() *logrus.Entry {
 
 func (l *loggerModule) InitModule(ctx context.Context, _ *Manager) (interface{}, error) {
 	l.Logger().Debug("init logger module")
-	return &l.presettings, nil
+	return &l.settings, nil
 }
 
 func (l *loggerModule) ConfigChanged() {
-	if l.settings == nil {
-		l.settings = &loggerSettings{}
-		*l.settings = l.presettings
-		l.reloadSettings()
-	} else if *l.settings != l.presettings {
-		*l.settings = l.presettings
-		l.reloadSettings()
+	if err := l.reloadSettings(l.settings.Load()); err != nil {
+		l.Logger().Error("apply logger settings error, ", err)
 	}
 }
 
-func (l *loggerModule) reloadSettings() error {
-	if strings.EqualFold(l.settings.Formatter, "text") {
+func (l *loggerModule) reloadSettings(settings loggerSettings) error {
+	if strings.EqualFold(settings.Formatter, "text") {
 		logrus.SetFormatter(&logrus.TextFormatter{
 			FullTimestamp:   true,
-			ForceColors:     l.settings.Color && l.settings.Console,
-			DisableColors:   !l.settings.Color || !l.settings.Console,
-			TimestampFormat: l.settings.Format,
-			DisableSorting:  l.settings.DisableSorting,
+			ForceColors:     settings.Color && settings.Console,
+			DisableColors:   !settings.Color || !settings.Console,
+			TimestampFormat: settings.Format,
+			DisableSorting:  settings.DisableSorting,
 		})
 	} else {
 		logrus.SetFormatter(&logrus.JSONFormatter{
-			TimestampFormat: l.settings.Format,
+			TimestampFormat: settings.Format,
 		})
 	}
 
-	logrus.SetReportCaller(l.settings.ReportCaller)
-	level, err := logrus.ParseLevel(l.settings.Level)
+	logrus.SetReportCaller(settings.ReportCaller)
+	level, err := logrus.ParseLevel(settings.Level)
 	if err != nil {
 		return err
 	}
 	logrus.SetLevel(level)
 	var writer *rotatelogs.RotateLogs
 
-	if l.settings.File != "" {
-		filePattern := l.settings.File
-		if l.settings.FilePattern != "" {
-			filePattern += "." + l.settings.FilePattern
+	if settings.File != "" {
+		filePattern := settings.File
+		if settings.FilePattern != "" {
+			filePattern += "." + settings.FilePattern
 		}
 
 		logrus.Debug("filePattern ", filePattern)
-		if l.settings.MaxAge > 0 {
-			if l.settings.RotationTime == 0 {
-				l.settings.RotationTime = 24
-			}
-			if l.settings.RotationSize == 0 {
-				l.settings.RotationSize = 100
-			}
+		if settings.MaxAge > 0 {
 			writer, err = rotatelogs.New(
 				filePattern,
-				rotatelogs.WithLinkName(l.settings.File),
-				rotatelogs.WithMaxAge(time.Duration(l.settings.MaxAge)*time.Hour),
-				rotatelogs.WithRotationSize(int64(l.settings.RotationSize)*1024*1024),
-				rotatelogs.WithRotationTime(time.Duration(l.settings.RotationTime)*time.Hour),
+				rotatelogs.WithLinkName(settings.File),
+				rotatelogs.WithMaxAge(time.Duration(settings.MaxAge)*time.Hour),
+				rotatelogs.WithRotationSize(int64(settings.RotationSize)*1024*1024),
+				rotatelogs.WithRotationTime(time.Duration(settings.RotationTime)*time.Hour),
 			)
 			if err != nil {
 				return err
 			}
-		} else if l.settings.RotationCount > 0 {
-			if l.settings.RotationTime == 0 {
-				l.settings.RotationTime = 24
-			}
-
-			if l.settings.RotationSize == 0 {
-				l.settings.RotationSize = 100
-			}
-
-			if l.settings.RotationCount == 0 {
-				l.settings.RotationCount = 5
-			}
-
+		} else if settings.RotationCount > 0 {
 			writer, err = rotatelogs.New(
 				filePattern,
-				rotatelogs.WithLinkName(l.settings.File),
-				rotatelogs.WithRotationCount(uint(l.settings.RotationCount)),
-				rotatelogs.WithRotationSize(int64(l.settings.RotationSize)*1024*1024),
-				rotatelogs.WithRotationTime(time.Duration(l.settings.RotationTime)*time.Hour),
+				rotatelogs.WithLinkName(settings.File),
+				rotatelogs.WithRotationCount(uint(settings.RotationCount)),
+				rotatelogs.WithRotationSize(int64(settings.RotationSize)*1024*1024),
+				rotatelogs.WithRotationTime(time.Duration(settings.RotationTime)*time.Hour),
 			)
 			if err != nil {
 				return err
@@ -142,9 +118,9 @@ func (l *loggerModule) reloadSettings() error {
 	}
 
 	var output io.Writer
-	if l.settings.Console && writer != nil {
+	if settings.Console && writer != nil {
 		output = io.MultiWriter(writer, os.Stdout)
-	} else if l.settings.Console {
+	} else if settings.Console {
 		output = os.Stdout
 	} else if writer != nil {
 		output = writer
@@ -0,0 +1,22 @@
+package gomodule
+
+import "testing"
+
+func TestLoggerSettingsIgnoreCase(t *testing.T) {
+	c := newTestConfig(t, "logger:\n  formatter: TEXT\n  level: INFO\n")
+	c.mtx.Lock()
+	v, _, err := c.buildConfig()
+	c.mtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.stageSettings(v, "logger", &Settings[loggerSettings]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	v.Set("logger.level", "verbose")
+	if _, err := c.stageSettings(v, "logger", &Settings[loggerSettings]{}); err == nil {
+		t.Fatal("unknown level accepted")
+	}
+}
@@ -0,0 +1,45 @@
+package gomodule
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// GetAll returns every module and feature registered on m which is
+// assignable to T, in the same order RequireFeatures searches them. T is
+// usually an interface implemented by the feature or a module pointer type.
+func GetAll[T any](m *Manager) []T {
+	m.lock.RLock()
+	allFeatures := m.allFeatures()
+	m.lock.RUnlock()
+
+	result := make([]T, 0)
+	for _, f := range allFeatures {
+		if v, ok := f.(T); ok {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// Get returns the first module or feature registered on m which is
+// assignable to T.
+func Get[T any](m *Manager) (T, error) {
+	if all := GetAll[T](m); len(all) > 0 {
+		return all[0], nil
+	}
+
+	var zero T
+	return zero, fmt.Errorf("feature %s not found", reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// MustGet is like Get but panics if no feature is assignable to T.
+func MustGet[T any](m *Manager) T {
+	v, err := Get[T](m)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
@@ -0,0 +1,408 @@
+package gomodule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var metricsInstance *metricsModule
+
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// DefaultBuckets are the histogram buckets used when none are given.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricKind string
+
+const (
+	kindCounter   metricKind = "counter"
+	kindGauge     metricKind = "gauge"
+	kindHistogram metricKind = "histogram"
+)
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+}
+
+type metric struct {
+	name       string
+	help       string
+	kind       metricKind
+	labelNames []string
+	buckets    []float64
+	mtx        sync.Mutex
+	series     map[string]*metricSeries
+}
+
+// Counter is a monotonically increasing metric, optionally split by labels.
+type Counter struct {
+	m *metric
+}
+
+// Gauge is a metric which can go up and down, optionally split by labels.
+type Gauge struct {
+	m *metric
+}
+
+// Histogram samples observations into cumulative buckets, optionally split by
+// labels.
+type Histogram struct {
+	m *metric
+}
+
+type metricsSettings struct {
+	Path string `mapstructure:"path" default:"/metrics" validate:"required"`
+}
+
+type metricsModule struct {
+	DefaultModule
+	settings Settings[metricsSettings]
+	mtx      sync.Mutex
+	metrics  []*metric
+	logger   *logrus.Entry
+
+	initDuration   *Gauge
+	running        *Gauge
+	restarts       *Counter
+	panics         *Counter
+	reloads        *Counter
+	reloadFailures *Counter
+	lastReload     *Gauge
+	logMessages    *Counter
+}
+
+type logLevelHook struct {
+	counter *Counter
+}
+
+func init() {
+	metricsInstance = &metricsModule{
+		logger: logrus.WithField("module", "metrics"),
+	}
+
+	metricsInstance.initDuration, _ = metricsInstance.NewGauge("gomodule_module_init_duration_seconds",
+		"Time spent in InitModule.", "module")
+	metricsInstance.running, _ = metricsInstance.NewGauge("gomodule_module_running",
+		"Whether the module run loop is running.", "module")
+	metricsInstance.restarts, _ = metricsInstance.NewCounter("gomodule_module_restarts_total",
+		"Number of times the module was restarted by its supervisor.", "module")
+	metricsInstance.panics, _ = metricsInstance.NewCounter("gomodule_module_panics_total",
+		"Number of panics recovered from the module run loop.", "module")
+	metricsInstance.reloads, _ = metricsInstance.NewCounter("gomodule_config_reloads_total",
+		"Number of config reloads.", "source")
+	metricsInstance.reloadFailures, _ = metricsInstance.NewCounter("gomodule_config_reload_failures_total",
+		"Number of failed config reloads.", "source")
+	metricsInstance.lastReload, _ = metricsInstance.NewGauge("gomodule_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful config reload.", "source")
+	metricsInstance.logMessages, _ = metricsInstance.NewCounter("gomodule_log_messages_total",
+		"Number of log messages written.", "level")
+}
+
+func MetricsModule() IModule {
+	return metricsInstance
+}
+
+func (mm *metricsModule) Logger() *logrus.Entry {
+	return mm.logger
+}
+
+func (mm *metricsModule) Type() interface{} {
+	return mm
+}
+
+func (mm *metricsModule) InitModule(ctx context.Context, m *Manager) (interface{}, error) {
+	mm.Logger().Debug("init metrics module")
+	mm.Manager = m
+	logrus.AddHook(&logLevelHook{counter: mm.logMessages})
+	return &mm.settings, nil
+}
+
+func (mm *metricsModule) PreModuleRun() {
+	HandleAdmin(mm.settings.Load().Path, mm)
+}
+
+// observe turns lifecycle events into the built-in metrics.
+func (mm *metricsModule) observe(e Event) {
+	switch e.Phase {
+	case PhaseInitialized:
+		mm.initDuration.Set(e.Duration.Seconds(), e.Module)
+	case PhaseRunning:
+		mm.running.Set(1, e.Module)
+	case PhaseFailed:
+		mm.running.Set(0, e.Module)
+		var pe *PanicError
+		if errors.As(e.Err, &pe) {
+			mm.panics.Inc(e.Module)
+		}
+	case PhaseExited, PhaseStopped:
+		mm.running.Set(0, e.Module)
+	case PhaseRestarting:
+		mm.restarts.Inc(e.Module)
+	case PhaseConfigReloaded:
+		mm.reloads.Inc(e.Source)
+		if e.Err != nil {
+			mm.reloadFailures.Inc(e.Source)
+		} else {
+			mm.lastReload.Set(float64(e.Time.Add(e.Duration).UnixNano())/1e9, e.Source)
+		}
+	}
+}
+
+func (mm *metricsModule) register(name, help string, kind metricKind, buckets []float64, labelNames []string) (*metric, error) {
+	if !metricNameRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid metric name: %s", name)
+	}
+
+	for _, l := range labelNames {
+		if !metricNameRE.MatchString(l) || strings.Contains(l, ":") {
+			return nil, fmt.Errorf("invalid label name %s of metric %s", l, name)
+		}
+	}
+
+	mm.mtx.Lock()
+	defer mm.mtx.Unlock()
+
+	for _, m := range mm.metrics {
+		if m.name == name {
+			return nil, fmt.Errorf("metric[%s] already exists", name)
+		}
+	}
+
+	m := &metric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*metricSeries),
+	}
+	mm.metrics = append(mm.metrics, m)
+
+	return m, nil
+}
+
+func (mm *metricsModule) NewCounter(name, help string, labelNames ...string) (*Counter, error) {
+	m, err := mm.register(name, help, kindCounter, nil, labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Counter{m: m}, nil
+}
+
+func (mm *metricsModule) NewGauge(name, help string, labelNames ...string) (*Gauge, error) {
+	m, err := mm.register(name, help, kindGauge, nil, labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gauge{m: m}, nil
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds, or
+// DefaultBuckets when buckets is empty.
+func (mm *metricsModule) NewHistogram(name, help string, buckets []float64, labelNames ...string) (*Histogram, error) {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	m, err := mm.register(name, help, kindHistogram, sorted, labelNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Histogram{m: m}, nil
+}
+
+// with returns the series for labelValues, creating it on first use. Missing
+// label values are treated as empty strings. The caller must hold m.mtx.
+func (m *metric) with(labelValues []string) *metricSeries {
+	values := make([]string, len(m.labelNames))
+	copy(values, labelValues)
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: values}
+		if m.kind == kindHistogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+
+	c.m.mtx.Lock()
+	defer c.m.mtx.Unlock()
+
+	c.m.with(labelValues).value += v
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mtx.Lock()
+	defer g.m.mtx.Unlock()
+
+	g.m.with(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mtx.Lock()
+	defer g.m.mtx.Unlock()
+
+	g.m.with(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mtx.Lock()
+	defer h.m.mtx.Unlock()
+
+	s := h.m.with(labelValues)
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveDuration observes the seconds elapsed since start.
+func (h *Histogram) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(values[i])))
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// write renders m in the Prometheus text exposition format.
+func (m *metric) write(buf *bytes.Buffer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, helpReplacer.Replace(m.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != kindHistogram {
+			fmt.Fprintf(buf, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range m.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), s.count)
+	}
+}
+
+func (mm *metricsModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mm.mtx.Lock()
+	metrics := mm.metrics
+	mm.mtx.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, m := range metrics {
+		m.write(buf)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (h *logLevelHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logLevelHook) Fire(entry *logrus.Entry) error {
+	h.counter.Inc(entry.Level.String())
+	return nil
+}
+
+func NewCounter(name, help string, labelNames ...string) (*Counter, error) {
+	return metricsInstance.NewCounter(name, help, labelNames...)
+}
+
+func NewGauge(name, help string, labelNames ...string) (*Gauge, error) {
+	return metricsInstance.NewGauge(name, help, labelNames...)
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) (*Histogram, error) {
+	return metricsInstance.NewHistogram(name, help, buckets, labelNames...)
+}
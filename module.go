@@ -6,19 +6,28 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var defaultmanager *Manager
 
 type ModuleInfo struct {
-	module   IModule
-	settings interface{}
-	cmds     []*cobra.Command
-	name     string
+	module    IModule
+	settings  interface{}
+	cmds      []*cobra.Command
+	name      string
+	done      chan struct{}
+	isDefault bool
+	state     ModuleState
+	startTime time.Time
+	lastErr   error
+	restarts  int
 }
 
 type Manager struct {
@@ -31,11 +40,33 @@ type Manager struct {
 	defaultModules     []*ModuleInfo
 	roomCmdRun         bool
 	servctl            *servctl
-	featureResolutions []resolution
+	featureResolutions []*resolution
+	featureErrors      []string
 	features           []Feature
 	lock               sync.RWMutex
-}
-
+	shutdownTimeout    time.Duration
+	moduleStopTimeout  time.Duration
+	shutdownOnce       sync.Once
+	shutdownErr        error
+	restartPolicies    map[string]RestartPolicy
+	fatalErr           error
+	logger             *logrus.Entry
+	eventLock          sync.RWMutex
+	subscribers        []*subscriber
+}
+
+// SettingsChangeListener is implemented by modules which want to know how
+// their settings changed. SettingsChanged is called after ConfigChanged with
+// copies of the previous and new settings values and the config paths, below
+// the module key, of the fields which differ.
+type SettingsChangeListener interface {
+	SettingsChanged(old, new interface{}, paths []string)
+}
+
+// IModule is implemented by every module. ModuleRun is run in its own
+// goroutine; modules which can fail should also implement RunnerE.
+// ConfigChanged is called once the config is first loaded, then whenever a
+// reload changes the module's settings.
 type IModule interface {
 	Feature
 	InitModule(ctx context.Context, m *Manager) (interface{}, error)
@@ -70,10 +101,13 @@ func (m *Manager) sysSignal() {
 
 func NewManager() *Manager {
 	m := &Manager{
-		modules:        make([]*ModuleInfo, 0),
-		rootCmd:        &cobra.Command{},
-		defaultModules: make([]*ModuleInfo, 0),
-		roomCmdRun:     false,
+		modules:           make([]*ModuleInfo, 0),
+		rootCmd:           &cobra.Command{},
+		defaultModules:    make([]*ModuleInfo, 0),
+		roomCmdRun:        false,
+		shutdownTimeout:   defaultShutdownTimeout,
+		moduleStopTimeout: defaultModuleStopTimeout,
+		logger:            logrus.WithField("module", "manager"),
 	}
 
 	m.servctl = newServctl(m)
@@ -137,16 +171,35 @@ func (m *Manager) RegisterDefaultModule(module IModule) error {
 }
 
 func (m *Manager) RegisterDefaultModules() {
-	if e := RegisterDefaultModuleWithName(ConfigModule(), "config"); e != nil {
+	if e := m.RegisterDefaultModuleWithName(ConfigModule(), "config"); e != nil {
+		panic(e)
+	}
+
+	if e := m.RegisterDefaultModuleWithName(LoggerModule(), "logger"); e != nil {
 		panic(e)
 	}
 
-	if e := RegisterDefaultModuleWithName(LoggerModule(), "logger"); e != nil {
+	if e := m.RegisterDefaultModuleWithName(AdminModule(), "admin"); e != nil {
 		panic(e)
 	}
+
+	if e := m.RegisterDefaultModuleWithName(MetricsModule(), "metrics"); e != nil {
+		panic(e)
+	}
+	m.Subscribe(metricsInstance.observe)
 }
 
 func (m *Manager) RegisterWithName(module IModule, name string) error {
+	if e := m.registerWithName(module, name); e != nil {
+		return e
+	}
+
+	m.resolveFeatures(nil)
+
+	return nil
+}
+
+func (m *Manager) registerWithName(module IModule, name string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -169,12 +222,23 @@ func (m *Manager) RegisterWithName(module IModule, name string) error {
 		module: module,
 		cmds:   make([]*cobra.Command, 0),
 		name:   name,
+		state:  StateRegistered,
 	})
 
 	return nil
 }
 
 func (m *Manager) RegisterDefaultModuleWithName(module IModule, name string) error {
+	if e := m.registerDefaultModuleWithName(module, name); e != nil {
+		return e
+	}
+
+	m.resolveFeatures(nil)
+
+	return nil
+}
+
+func (m *Manager) registerDefaultModuleWithName(module IModule, name string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -194,9 +258,11 @@ func (m *Manager) RegisterDefaultModuleWithName(module IModule, name string) err
 	}
 
 	m.defaultModules = append(m.defaultModules, &ModuleInfo{
-		module: module,
-		cmds:   make([]*cobra.Command, 0),
-		name:   name,
+		module:    module,
+		cmds:      make([]*cobra.Command, 0),
+		name:      name,
+		isDefault: true,
+		state:     StateRegistered,
 	})
 
 	return nil
@@ -224,27 +290,51 @@ func (m *Manager) Run(ctx context.Context) error {
 		return e
 	}
 
-	m.Wait()
-
-	return nil
+	return m.Wait()
 }
 
 func (m *Manager) GetRootCmd() *cobra.Command {
 	return m.rootCmd
 }
 
-func (m *Manager) Wait() {
+// Wait blocks until the manager context is cancelled, then runs the shutdown
+// phase and returns once every ModuleRun has exited or the shutdown deadline
+// has passed. The first fatal module error is returned; when the shutdown
+// failed too, it is the Cause of the returned *ShutdownError.
+func (m *Manager) Wait() error {
 	go func() {
 		m.wg.Wait()
 		m.cancel()
 	}()
 
 	<-m.ctx.Done()
+
+	err := m.shutdown()
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.fatalErr == nil {
+		return err
+	}
+
+	if se, ok := err.(*ShutdownError); ok {
+		return &ShutdownError{Failures: se.Failures, Cause: m.fatalErr}
+	}
+
+	return m.fatalErr
 }
 
-func (m *Manager) configChanged() {
-	for _, mi := range m.modules {
-		mi.module.ConfigChanged()
+// configChanged calls ConfigChanged, then SettingsChanged if implemented, on
+// the module of every change, in order.
+func (m *Manager) configChanged(changes []*settingsChange) {
+	for _, ch := range changes {
+		start := time.Now()
+		ch.module.module.ConfigChanged()
+		if l, ok := ch.module.module.(SettingsChangeListener); ok && ch.new != nil {
+			l.SettingsChanged(ch.old, ch.new, ch.paths)
+		}
+		m.emit(Event{Module: ch.module.name, Phase: PhaseConfigChanged, Time: start, Duration: time.Since(start)})
 	}
 }
 
@@ -256,16 +346,21 @@ func (m *Manager) initWaitGroup() {
 
 func (m *Manager) run() {
 	for _, mi := range m.modules {
+		start := time.Now()
 		mi.module.PreModuleRun()
+		m.emit(Event{Module: mi.name, Phase: PhasePreRun, Time: start, Duration: time.Since(start)})
 	}
 
 	m.initWaitGroup()
 
+	m.lock.Lock()
+	for _, mi := range m.modules {
+		mi.done = make(chan struct{})
+	}
+	m.lock.Unlock()
+
 	for _, mi := range m.modules {
-		go func(mi *ModuleInfo) {
-			defer m.wg.Done()
-			mi.module.ModuleRun()
-		}(mi)
+		go m.superviseModule(mi)
 	}
 }
 
@@ -277,9 +372,15 @@ func (m *Manager) initModules(ctx context.Context) error {
 	m.ctx, m.cancel = context.WithCancel(ctx)
 	m.initDefaultModules()
 
+	if e := m.sortModules(); e != nil {
+		return e
+	}
+
 	// init module
 	for _, mi := range m.modules {
+		start := time.Now()
 		settings, err := mi.module.InitModule(m.ctx, m)
+		m.emit(Event{Module: mi.name, Phase: PhaseInitialized, Time: start, Duration: time.Since(start), Err: err})
 		if err != nil {
 			return err
 		}
@@ -288,7 +389,9 @@ func (m *Manager) initModules(ctx context.Context) error {
 
 	// init command
 	for _, mi := range m.modules {
+		start := time.Now()
 		cmds, err := mi.module.InitCommand()
+		m.emit(Event{Module: mi.name, Phase: PhaseCommandsRegistered, Time: start, Duration: time.Since(start), Err: err})
 		if err != nil {
 			return err
 		}
@@ -300,7 +403,9 @@ func (m *Manager) initModules(ctx context.Context) error {
 		mi.cmds = cmds
 	}
 
-	return nil
+	m.rootCmd.AddCommand(m.modulesCommand())
+
+	return m.unresolvedFeatures()
 }
 
 func (m *Manager) Stop() {
@@ -316,40 +421,59 @@ type resolution struct {
 	callback interface{}
 }
 
+// getFeature looks a feature up by the Type() convention first, then falls
+// back to the first feature assignable to the type t points to.
 func getFeature(allFeatures []Feature, t reflect.Type) Feature {
 	for _, m := range allFeatures {
 		if reflect.TypeOf(m.Type()) == t {
 			return m
 		}
 	}
+
+	for _, m := range allFeatures {
+		if reflect.TypeOf(m).AssignableTo(t.Elem()) {
+			return m
+		}
+	}
 	return nil
 }
 
-func (r *resolution) resolve(allFeatures []Feature) (bool, error) {
-	var ms []Feature
+func (r *resolution) missing(allFeatures []Feature) []reflect.Type {
+	missing := make([]reflect.Type, 0)
 	for _, d := range r.deps {
-		m := getFeature(allFeatures, d)
-		if m == nil {
-			return false, nil
+		if getFeature(allFeatures, d) == nil {
+			missing = append(missing, d)
 		}
-		ms = append(ms, m)
 	}
 
+	return missing
+}
+
+func (r *resolution) String() string {
+	names := make([]string, 0, len(r.deps))
+	for _, d := range r.deps {
+		names = append(names, d.Elem().String())
+	}
+
+	return fmt.Sprintf("func(%s)", strings.Join(names, ", "))
+}
+
+func (r *resolution) resolve(allFeatures []Feature) error {
 	callback := reflect.ValueOf(r.callback)
-	var input []reflect.Value
 	callbackType := callback.Type()
+	input := make([]reflect.Value, 0, callbackType.NumIn())
 	for i := 0; i < callbackType.NumIn(); i++ {
 		pt := callbackType.In(i)
-		for _, m := range ms {
-			if reflect.TypeOf(m).AssignableTo(pt) {
-				input = append(input, reflect.ValueOf(m))
-				break
-			}
+		f := getFeature(allFeatures, r.deps[i])
+		if f == nil {
+			return fmt.Errorf("feature %s not found", pt)
+		}
+
+		if !reflect.TypeOf(f).AssignableTo(pt) {
+			return fmt.Errorf("feature %T is not assignable to %s", f, pt)
 		}
-	}
 
-	if len(input) != callbackType.NumIn() {
-		panic("Can't get all input parameters")
+		input = append(input, reflect.ValueOf(f))
 	}
 
 	var err error
@@ -365,74 +489,152 @@ func (r *resolution) resolve(allFeatures []Feature) (bool, error) {
 		}
 	}
 
-	return true, err
+	return err
 }
 
-func (m *Manager) RequireFeatures(callback interface{}) error {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	callbackType := reflect.TypeOf(callback)
-	if callbackType.Kind() != reflect.Func {
-		panic("not a function")
+// moduleInfos returns the default modules followed by the registered ones,
+// whether or not the manager has merged them yet. The caller must hold
+// m.lock.
+func (m *Manager) moduleInfos() []*ModuleInfo {
+	infos := make([]*ModuleInfo, 0, len(m.defaultModules)+len(m.modules))
+	seen := make(map[*ModuleInfo]bool)
+	for _, mi := range m.defaultModules {
+		seen[mi] = true
+		infos = append(infos, mi)
 	}
 
-	var featureTypes []reflect.Type
-	for i := 0; i < callbackType.NumIn(); i++ {
-		featureTypes = append(featureTypes, reflect.PointerTo(callbackType.In(i)))
+	for _, mi := range m.modules {
+		if !seen[mi] {
+			infos = append(infos, mi)
+		}
 	}
 
-	r := resolution{
-		deps:     featureTypes,
-		callback: callback,
-	}
+	return infos
+}
 
-	allFeatures := make([]Feature, 0)
-	for _, mi := range m.modules {
+// allFeatures returns every module and feature known to the manager, in a
+// stable order: default modules, registered modules, then added features.
+// The caller must hold m.lock.
+func (m *Manager) allFeatures() []Feature {
+	allFeatures := make([]Feature, 0, len(m.defaultModules)+len(m.modules)+len(m.features))
+	for _, mi := range m.moduleInfos() {
 		allFeatures = append(allFeatures, mi.module)
 	}
 
-	for _, mi := range m.defaultModules {
-		allFeatures = append(allFeatures, mi.module)
+	return append(allFeatures, m.features...)
+}
+
+// resolveFeatures fires, in the order they were required, every pending
+// resolution whose dependencies are now available. Each resolution is removed
+// from the pending list before its callback runs, so it fires exactly once.
+// Only the error of own is returned, to the RequireFeatures call which added
+// it; the errors of the other callbacks are recorded and reported by Launch.
+func (m *Manager) resolveFeatures(own *resolution) error {
+	m.lock.Lock()
+	allFeatures := m.allFeatures()
+	ready := make([]*resolution, 0)
+	pending := make([]*resolution, 0, len(m.featureResolutions))
+	for _, r := range m.featureResolutions {
+		if len(r.missing(allFeatures)) == 0 {
+			ready = append(ready, r)
+		} else {
+			pending = append(pending, r)
+		}
 	}
+	m.featureResolutions = pending
+	m.lock.Unlock()
 
-	allFeatures = append(allFeatures, m.features...)
+	var ownErr error
+	for _, r := range ready {
+		e := r.resolve(allFeatures)
+		if e == nil {
+			continue
+		}
 
-	if finished, err := r.resolve(allFeatures); finished {
-		return err
-	}
+		if r == own {
+			ownErr = e
+			continue
+		}
 
-	m.featureResolutions = append(m.featureResolutions, r)
+		m.logger.Errorf("feature callback %s failed, %s", r, e)
+		m.lock.Lock()
+		m.featureErrors = append(m.featureErrors, fmt.Sprintf("%s: %s", r, e))
+		m.lock.Unlock()
+	}
 
-	return fmt.Errorf("can't resolve all dependencies")
+	return ownErr
 }
 
-func (m *Manager) AddFeature(feature Feature) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// unresolvedFeatures reports the resolutions still pending, naming the
+// feature types each one is missing, and the deferred callbacks which
+// failed.
+func (m *Manager) unresolvedFeatures() error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-	if feature == nil {
-		return fmt.Errorf("feature is nil")
+	msgs := make([]string, 0, len(m.featureResolutions))
+	if len(m.featureResolutions) > 0 {
+		allFeatures := m.allFeatures()
+		for _, r := range m.featureResolutions {
+			names := make([]string, 0)
+			for _, t := range r.missing(allFeatures) {
+				names = append(names, t.Elem().String())
+			}
+			msgs = append(msgs, fmt.Sprintf("%s missing %s", r, strings.Join(names, ", ")))
+		}
 	}
 
-	m.features = append(m.features, feature)
+	switch {
+	case len(msgs) > 0 && len(m.featureErrors) > 0:
+		return fmt.Errorf("unresolved features: %s; resolve features failed, %s", strings.Join(msgs, "; "), strings.Join(m.featureErrors, "; "))
+	case len(msgs) > 0:
+		return fmt.Errorf("unresolved features: %s", strings.Join(msgs, "; "))
+	case len(m.featureErrors) > 0:
+		return fmt.Errorf("resolve features failed, %s", strings.Join(m.featureErrors, "; "))
+	}
 
-	allFeatures := make([]Feature, 0)
-	for _, mi := range m.modules {
-		allFeatures = append(allFeatures, mi.module)
+	return nil
+}
+
+// RequireFeatures calls callback with the features matching its parameter
+// types. If some of them are not available yet the callback is deferred and
+// fired once they are registered. The error of a callback fired at once is
+// returned; requirements still unresolved, or deferred callbacks which
+// failed, make Launch fail.
+func (m *Manager) RequireFeatures(callback interface{}) error {
+	callbackType := reflect.TypeOf(callback)
+	if callbackType == nil || callbackType.Kind() != reflect.Func {
+		return fmt.Errorf("callback is not a function")
 	}
 
-	for _, mi := range m.defaultModules {
-		allFeatures = append(allFeatures, mi.module)
+	var featureTypes []reflect.Type
+	for i := 0; i < callbackType.NumIn(); i++ {
+		featureTypes = append(featureTypes, reflect.PointerTo(callbackType.In(i)))
 	}
 
-	allFeatures = append(allFeatures, m.features...)
+	r := &resolution{
+		deps:     featureTypes,
+		callback: callback,
+	}
 
-	for _, r := range m.featureResolutions {
-		if finished, err := r.resolve(allFeatures); finished {
-			return err
-		}
+	m.lock.Lock()
+	m.featureResolutions = append(m.featureResolutions, r)
+	m.lock.Unlock()
+
+	return m.resolveFeatures(r)
+}
+
+func (m *Manager) AddFeature(feature Feature) error {
+	if feature == nil {
+		return fmt.Errorf("feature is nil")
 	}
 
+	m.lock.Lock()
+	m.features = append(m.features, feature)
+	m.lock.Unlock()
+
+	m.resolveFeatures(nil)
+
 	return nil
 }
 
@@ -468,8 +670,8 @@ func GetRootCmd() *cobra.Command {
 	return defaultmanager.GetRootCmd()
 }
 
-func Wait() {
-	defaultmanager.Wait()
+func Wait() error {
+	return defaultmanager.Wait()
 }
 
 func Stop() {
@@ -0,0 +1,58 @@
+package gomodule
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRequireFeatures(t *testing.T) {
+	m := NewManager()
+
+	var callsA, callsAB int
+	if err := m.RequireFeatures(func(*depA) error {
+		callsA++
+		return errors.New("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RequireFeatures(func(*depA, *depB) {
+		callsAB++
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failing callback is not the registration's error
+	if err := m.RegisterWithName(&depA{}, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.unresolvedFeatures()
+	if callsA != 1 || callsAB != 0 || err == nil || !strings.Contains(err.Error(), "missing *gomodule.depB") || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got %d, %d calls, error %v", callsA, callsAB, err)
+	}
+
+	if err := m.RegisterWithName(&depB{}, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddFeature(&depFeature{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if callsA != 1 || callsAB != 1 {
+		t.Fatalf("got %d, %d calls", callsA, callsAB)
+	}
+
+	// a callback fired at once returns its own error only
+	if err := m.RequireFeatures(func(*depB) error {
+		return errors.New("own")
+	}); err == nil || err.Error() != "own" {
+		t.Fatalf("got error %v", err)
+	}
+
+	if err := m.unresolvedFeatures(); err == nil || err.Error() != "resolve features failed, func(*gomodule.depA): boom" {
+		t.Fatalf("got error %v", err)
+	}
+}
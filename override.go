@@ -0,0 +1,351 @@
+package gomodule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceOverride is the kind of the layer of values set at runtime with Set
+// and Override, merged over every other source.
+const SourceOverride = "override"
+
+// treeKey returns the key of tree matching key case insensitively, like
+// viper compares keys, or key itself.
+func treeKey(tree map[string]interface{}, key string) string {
+	if _, ok := tree[key]; ok {
+		return key
+	}
+
+	for k := range tree {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+
+	return key
+}
+
+// setPath sets the value at the dotted path keys of tree, creating the
+// intermediate maps.
+func setPath(tree map[string]interface{}, keys []string, value interface{}) {
+	node := tree
+	for _, k := range keys[:len(keys)-1] {
+		k = treeKey(node, k)
+		sub, ok := node[k].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			node[k] = sub
+		}
+		node = sub
+	}
+	node[treeKey(node, keys[len(keys)-1])] = value
+}
+
+// mergeTree deep merges src into dst, src values winning.
+func mergeTree(dst, src map[string]interface{}) {
+	for k, v := range src {
+		k = treeKey(dst, k)
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeTree(dv, sv)
+				continue
+			}
+			v = copyTree(sv)
+		}
+		dst[k] = v
+	}
+}
+
+// copyTree deep copies the maps and slices of a config tree.
+func copyTree(tree map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(tree))
+	for k, v := range tree {
+		cp[k] = copyValue(v)
+	}
+
+	return cp
+}
+
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return copyTree(val)
+	case []interface{}:
+		cp := make([]interface{}, len(val))
+		for i, item := range val {
+			cp[i] = copyValue(item)
+		}
+		return cp
+	default:
+		return v
+	}
+}
+
+// applyOverride changes the override layer with change and reloads. When
+// the reload fails only the values change set are reverted, so the config
+// stays at the last good one and overrides applied meanwhile by other calls
+// are kept.
+func (c *configModule) applyOverride(change func(overrides map[string]interface{})) error {
+	c.mtx.Lock()
+	previous := copyTree(c.overrides)
+	overrides := copyTree(previous)
+	change(overrides)
+	c.overrides = overrides
+	c.mtx.Unlock()
+
+	if err := c.reload(SourceOverride); err != nil {
+		c.mtx.Lock()
+		current := copyTree(c.overrides)
+		for _, keys := range changedPaths(nil, previous, overrides) {
+			restorePath(current, previous, overrides, keys)
+		}
+		c.overrides = current
+		c.mtx.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// changedPaths returns the paths of the values which differ between the
+// trees a and b.
+func changedPaths(path []string, a, b map[string]interface{}) [][]string {
+	paths := make([][]string, 0)
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		keyPath := append(append([]string(nil), path...), k)
+		av, aok := a[k]
+		bv, bok := b[k]
+		am, amap := av.(map[string]interface{})
+		bm, bmap := bv.(map[string]interface{})
+		switch {
+		case amap && bmap:
+			paths = append(paths, changedPaths(keyPath, am, bm)...)
+		case aok != bok || !reflect.DeepEqual(av, bv):
+			paths = append(paths, keyPath)
+		}
+	}
+
+	return paths
+}
+
+// getPath returns the value at keys in tree.
+func getPath(tree map[string]interface{}, keys []string) (interface{}, bool) {
+	var v interface{} = tree
+	for _, k := range keys {
+		node, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = node[treeKey(node, k)]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// restorePath sets the value at keys in tree back to its value in from, or
+// removes it when from has none. A value changed since it was set to the one
+// in to is left alone.
+func restorePath(tree, from, to map[string]interface{}, keys []string) {
+	current, ok := getPath(tree, keys)
+	want, wantOK := getPath(to, keys)
+	if ok != wantOK || !reflect.DeepEqual(current, want) {
+		return
+	}
+
+	if v, ok := getPath(from, keys); ok {
+		setPath(tree, keys, copyValue(v))
+		return
+	}
+
+	parent, ok := getPath(tree, keys[:len(keys)-1])
+	if node, isMap := parent.(map[string]interface{}); ok && isMap {
+		delete(node, treeKey(node, keys[len(keys)-1]))
+	}
+}
+
+// Set overrides the config value at a dotted key, e.g. logger.level, over
+// every source and reloads, so the change flows to the module's settings
+// and ConfigChanged. An invalid value is rejected and nothing changes.
+func (c *configModule) Set(key string, value interface{}) error {
+	keys := strings.Split(key, ".")
+	for _, k := range keys {
+		if k == "" {
+			return fmt.Errorf("invalid config key %q", key)
+		}
+	}
+
+	return c.applyOverride(func(overrides map[string]interface{}) {
+		setPath(overrides, keys, value)
+	})
+}
+
+// Override deep merges patch into the overrides of a module's settings and
+// reloads, like Set does for a single key.
+func (c *configModule) Override(module string, patch map[string]interface{}) error {
+	return c.applyOverride(func(overrides map[string]interface{}) {
+		key := treeKey(overrides, module)
+		sub, ok := overrides[key].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			overrides[key] = sub
+		}
+		mergeTree(sub, patch)
+	})
+}
+
+// ClearOverride drops every override of a module, or of all modules when
+// module is empty, and reloads.
+func (c *configModule) ClearOverride(module string) error {
+	return c.applyOverride(func(overrides map[string]interface{}) {
+		if module == "" {
+			for k := range overrides {
+				delete(overrides, k)
+			}
+			return
+		}
+
+		delete(overrides, treeKey(overrides, module))
+	})
+}
+
+// PersistOverrides writes the overrides into the first local config file,
+// which must be yaml or json, and clears them. Comments and key order of the
+// file are not kept. Values the file sets may still be overridden by later
+// sources. When the reload without the overrides fails, the file and the
+// overrides are restored.
+func (c *configModule) PersistOverrides() error {
+	c.mtx.Lock()
+
+	if len(c.localFiles) == 0 {
+		c.mtx.Unlock()
+		return fmt.Errorf("no local config file to persist to")
+	}
+
+	file := c.localFiles[0]
+	original, err := os.ReadFile(file)
+	if err != nil {
+		c.mtx.Unlock()
+		return fmt.Errorf("read config file error, %s", err)
+	}
+
+	if err := writeConfigFile(file, c.overrides); err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+
+	previous := c.overrides
+	c.overrides = nil
+	c.mtx.Unlock()
+
+	if err := c.reload(SourceOverride); err != nil {
+		// overrides set meanwhile win over the restored ones
+		c.mtx.Lock()
+		restored := copyTree(previous)
+		mergeTree(restored, c.overrides)
+		c.overrides = restored
+		c.mtx.Unlock()
+
+		if e := replaceFile(file, original); e != nil {
+			c.Logger().Error("restore config file error, ", e)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// writeConfigFile merges values into a yaml or json config file, keeping the
+// case of the keys it already has, and replaces it atomically.
+func writeConfigFile(file string, values map[string]interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read config file error, %s", err)
+	}
+
+	doc := make(map[string]interface{})
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("persist config to %s files not supported", ext)
+	}
+
+	if err != nil {
+		return fmt.Errorf("parse config file error, %s", err)
+	}
+
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	mergeTree(doc, values)
+
+	if ext == ".json" {
+		data, err = json.MarshalIndent(doc, "", "  ")
+	} else {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(doc)
+		data = buf.Bytes()
+	}
+
+	if err != nil {
+		return fmt.Errorf("encode config file error, %s", err)
+	}
+
+	return replaceFile(file, data)
+}
+
+// replaceFile atomically replaces the content of file, keeping its mode.
+func replaceFile(file string, data []byte) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if e := tmp.Chmod(fi.Mode()); err == nil {
+		err = e
+	}
+
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write config file error, %s", err)
+	}
+
+	return nil
+}
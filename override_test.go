@@ -0,0 +1,145 @@
+package gomodule
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: a\n  port: 8080\n")
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set("tmod.port", 9000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set("tmod.port", -1); err == nil {
+		t.Fatal("invalid override applied")
+	}
+
+	if settings.Port != 9000 {
+		t.Fatalf("got settings %+v", settings)
+	}
+
+	if err := c.PersistOverrides(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(c.localFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "tmod:\n  name: a\n  port: 9000\n" || settings.Port != 9000 || len(c.overrides) != 0 {
+		t.Fatalf("got file %q, settings %+v, overrides %v", data, settings, c.overrides)
+	}
+
+	if err := c.ClearOverride(""); err != nil || settings.Port != 9000 {
+		t.Fatalf("got settings %+v, %v", settings, err)
+	}
+}
+
+func TestPersistOverridesRollsBack(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  port: 8080\n")
+	c.flags.Set = []string{"tmod.port=-1"}
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	c.overrides = map[string]interface{}{"tmod": map[string]interface{}{"port": 9000}}
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the override the --cfg.set value is invalid
+	if err := c.PersistOverrides(); err == nil {
+		t.Fatal("persist succeeded")
+	}
+
+	data, err := os.ReadFile(c.localFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "tmod:\n  port: 8080\n" || len(c.overrides) == 0 || settings.Port != 9000 {
+		t.Fatalf("got file %q, overrides %v, settings %+v", data, c.overrides, settings)
+	}
+
+	if err := c.reload("test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverrideRollbackKeepsConcurrentChanges(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: a\n  port: 8080\n")
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set("tmod.port", 9000); err != nil {
+		t.Fatal(err)
+	}
+
+	// another override lands between the failed reload and the rollback
+	once := false
+	c.m.Subscribe(func(e Event) {
+		if e.Phase == PhaseConfigReloaded && e.Err != nil && !once {
+			once = true
+			if err := c.Override("tmod", map[string]interface{}{"name": "b", "port": 9001}); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	if err := c.Set("tmod.port", -1); err == nil {
+		t.Fatal("invalid override applied")
+	}
+
+	if settings.Name != "b" || settings.Port != 9001 {
+		t.Fatalf("got settings %+v", settings)
+	}
+
+	if err := c.reload("test"); err != nil || settings.Name != "b" || settings.Port != 9001 {
+		t.Fatalf("got settings %+v, overrides %v, error %v", settings, c.overrides, err)
+	}
+}
+
+func TestOverrideSecretsStayReferences(t *testing.T) {
+	t.Setenv("GOMODULE_TEST_SECRET", "hunter2")
+	c := newTestConfig(t, "tmod:\n  name: a\n")
+
+	var settings testSettings
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set("tmod.hosts", []interface{}{"${env:GOMODULE_TEST_SECRET}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(settings.Hosts) != 1 || settings.Hosts[0] != "hunter2" {
+		t.Fatalf("got settings %+v", settings)
+	}
+
+	if err := c.PersistOverrides(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(c.localFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "hunter2") || !strings.Contains(string(data), "${env:GOMODULE_TEST_SECRET}") {
+		t.Fatalf("got file %q", data)
+	}
+}
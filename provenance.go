@@ -0,0 +1,157 @@
+package gomodule
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SourceDefault is the kind of the source of values no config source sets,
+// which keep their default tag or zero value.
+const SourceDefault = "default"
+
+const redacted = "******"
+
+// ConfigValue is an effective settings value and the source which set it.
+// Values of fields tagged `sensitive:"true"`, and of every field nested in
+// them, are redacted.
+type ConfigValue struct {
+	Module string       `json:"module"`
+	Key    string       `json:"key"`
+	Value  interface{}  `json:"value"`
+	Source ConfigSource `json:"source"`
+}
+
+// EffectiveConfig returns every settings value currently applied, per module
+// in registration order, annotated with the last source setting it.
+func (c *configModule) EffectiveConfig() ([]ConfigValue, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.config == nil {
+		return nil, fmt.Errorf("config not loaded")
+	}
+
+	values := make([]ConfigValue, 0)
+	for _, mi := range c.m.modules {
+		if mi.settings != nil {
+			values = flattenSettings(values, mi.name, nil, currentSettings(mi.settings), false, c.layers)
+		}
+	}
+
+	names := make([]string, 0, len(c.dynamicConf))
+	for name := range c.dynamicConf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values = flattenSettings(values, name, nil, currentSettings(c.dynamicConf[name]), false, c.layers)
+	}
+
+	return values, nil
+}
+
+// stagedConfig loads every source once and returns the values the settings
+// would get, without applying them.
+func (c *configModule) stagedConfig() ([]ConfigValue, error) {
+	if err := c.loadSources(); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	v, layers, err := c.buildConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	staged, err := c.stageAll(v)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]ConfigValue, 0)
+	for _, ss := range staged {
+		values = flattenSettings(values, ss.name, nil, ss.value.Elem(), false, layers)
+	}
+
+	return values, nil
+}
+
+// flattenSettings appends a ConfigValue for every leaf field of the settings
+// value v, recursing into nested structs.
+func flattenSettings(values []ConfigValue, module string, path []string, v reflect.Value, sensitive bool, layers []configLayer) []ConfigValue {
+	if v.Kind() == reflect.Struct && v.Type() != timeType {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			key, ok := settingsKey(f)
+			if !ok {
+				continue
+			}
+
+			fieldPath := path
+			if key != "" {
+				fieldPath = append(append([]string(nil), path...), key)
+			}
+
+			values = flattenSettings(values, module, fieldPath, v.Field(i), sensitive || f.Tag.Get("sensitive") == "true", layers)
+		}
+		return values
+	}
+
+	value := v.Interface()
+	if sensitive && !v.IsZero() {
+		value = redacted
+	}
+
+	return append(values, ConfigValue{
+		Module: module,
+		Key:    strings.Join(path, "."),
+		Value:  value,
+		Source: valueSource(layers, append([]string{module}, path...)),
+	})
+}
+
+// valueSource returns the last layer setting path, keys compared case
+// insensitively like viper does.
+func valueSource(layers []configLayer, path []string) ConfigSource {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if hasKey(layers[i].values, path) {
+			return layers[i].source
+		}
+	}
+
+	return ConfigSource{Kind: SourceDefault}
+}
+
+func hasKey(tree map[string]interface{}, path []string) bool {
+	var node interface{} = tree
+	for _, key := range path {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		found := false
+		for k, v := range m {
+			if strings.EqualFold(k, key) {
+				node, found = v, true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,232 @@
+package gomodule
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// ConfigSchema returns a JSON Schema of the whole config document, with a
+// property per module key built from the module's settings struct: its
+// mapstructure keys, default tags and validate rules. Config keys are case
+// insensitive, the schema uses the keys as tagged.
+func (c *configModule) ConfigSchema() map[string]interface{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	properties := make(map[string]interface{})
+	for _, mi := range c.m.modules {
+		if mi.settings != nil {
+			properties[mi.name] = typeSchema(settingsType(mi.settings))
+		}
+	}
+
+	for name, val := range c.dynamicConf {
+		properties[name] = typeSchema(settingsType(val))
+	}
+
+	return map[string]interface{}{
+		"$schema":    jsonSchemaDraft,
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// typeSchema returns the JSON Schema of values of type t.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		return map[string]interface{}{
+			"type":        []string{"string", "integer"},
+			"description": "duration, e.g. 1m30s, or nanoseconds",
+		}
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		schema := map[string]interface{}{"type": "object"}
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		structSchema(t, properties, &required)
+		schema["properties"] = properties
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema adds a property for every field of the struct t, inlining
+// squashed structs.
+func structSchema(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key, ok := settingsKey(f)
+		if !ok {
+			continue
+		}
+
+		if key == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structSchema(ft, properties, required)
+			}
+			continue
+		}
+
+		schema := typeSchema(f.Type)
+		_, hasDefault := f.Tag.Lookup("default")
+		if hasDefault {
+			v := reflect.New(f.Type).Elem()
+			if err := setFromString(v, f.Tag.Get("default")); err == nil {
+				schema["default"] = schemaValue(v)
+			}
+		}
+
+		if f.Tag.Get("sensitive") == "true" {
+			schema["writeOnly"] = true
+		}
+
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			if ruleSchema(f.Type, rule, schema) && !hasDefault {
+				*required = append(*required, key)
+			}
+		}
+
+		properties[key] = schema
+	}
+}
+
+// ruleSchema adds the keywords matching a validate rule to schema, reporting
+// whether the rule is required.
+func ruleSchema(t reflect.Type, rule string, schema map[string]interface{}) bool {
+	name, arg := rule, ""
+	if idx := strings.Index(rule, "="); idx >= 0 {
+		name, arg = rule[:idx], rule[idx+1:]
+	}
+
+	switch name {
+	case "required":
+		switch t.Kind() {
+		case reflect.String:
+			schema["minLength"] = 1
+		case reflect.Slice, reflect.Map:
+			schema["minItems"] = 1
+		}
+		return true
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return false
+		}
+
+		keyword := map[reflect.Kind]string{
+			reflect.String: "Length",
+			reflect.Slice:  "Items",
+			reflect.Array:  "Items",
+			reflect.Map:    "Properties",
+		}[t.Kind()]
+		if keyword == "" {
+			keyword = map[string]string{"min": "minimum", "max": "maximum"}[name]
+		} else {
+			keyword = name + keyword
+		}
+		schema[keyword] = limit
+	case "oneof":
+		enum := make([]interface{}, 0)
+		for _, option := range strings.Split(arg, "|") {
+			v := reflect.New(t).Elem()
+			if err := setFromString(v, option); err == nil {
+				enum = append(enum, schemaValue(v))
+			}
+		}
+		schema["enum"] = enum
+	}
+
+	return false
+}
+
+// schemaValue returns v as it is written in a config file.
+func schemaValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return v.Interface().(interface{ String() string }).String()
+	}
+
+	return v.Interface()
+}
+
+// validateConfig loads every source once and stages the settings of every
+// module from it, applying the same rules as the schema, without applying
+// them. It also returns the top level keys no module reads.
+func (c *configModule) validateConfig() ([]string, error) {
+	if err := c.loadSources(); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	v, _, err := c.buildConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, mi := range c.m.modules {
+		if mi.settings != nil {
+			known[strings.ToLower(mi.name)] = true
+		}
+	}
+
+	for name := range c.dynamicConf {
+		known[strings.ToLower(name)] = true
+	}
+
+	unknown := make([]string, 0)
+	for key := range v.AllSettings() {
+		if !known[strings.ToLower(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	if _, err := c.stageAll(v); err != nil {
+		return unknown, fmt.Errorf("config invalid, %s", err)
+	}
+
+	return unknown, nil
+}
@@ -0,0 +1,178 @@
+package gomodule
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretRef matches ${env:NAME} and ${file:/path} references inside config
+// string values.
+var secretRef = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+const (
+	encPrefix = "ENC["
+	encSuffix = "]"
+)
+
+// loadSecretKey reads the AES key of ENC[...] values from the --cfg.key
+// file, which holds 16, 24 or 32 bytes, raw or base64 encoded.
+func (c *configModule) loadSecretKey() ([]byte, error) {
+	if c.flags.KeyFile == "" {
+		return nil, fmt.Errorf("no key file, set --cfg.key")
+	}
+
+	data, err := os.ReadFile(c.flags.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read key file error, %s", err)
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+
+	if validKeySize(len(data)) {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("key file %s must hold a 16, 24 or 32 byte key", c.flags.KeyFile)
+}
+
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// encryptValue seals plain with AES-GCM and returns it as ENC[base64 of
+// nonce followed by the ciphertext].
+func encryptValue(key []byte, plain string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed) + encSuffix, nil
+}
+
+func decryptValue(key []byte, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, encPrefix), encSuffix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value, %s", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value, too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt value error, %s", err)
+	}
+
+	return string(plain), nil
+}
+
+// secretResolver replaces secret references in config values. The key is
+// only read once an ENC[...] value is met.
+type secretResolver struct {
+	c   *configModule
+	key []byte
+}
+
+func (r *secretResolver) resolveString(s string) (string, error) {
+	if strings.HasPrefix(s, encPrefix) && strings.HasSuffix(s, encSuffix) {
+		if r.key == nil {
+			key, err := r.c.loadSecretKey()
+			if err != nil {
+				return "", err
+			}
+			r.key = key
+		}
+
+		return decryptValue(r.key, s)
+	}
+
+	var err error
+	resolved := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRef.FindStringSubmatch(ref)
+		switch m[1] {
+		case "env":
+			val, ok := os.LookupEnv(m[2])
+			if !ok && err == nil {
+				err = fmt.Errorf("env %s not set", m[2])
+			}
+			return val
+		default:
+			data, e := os.ReadFile(m[2])
+			if e != nil && err == nil {
+				err = fmt.Errorf("read secret file error, %s", e)
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+	})
+
+	return resolved, err
+}
+
+// resolve returns a copy of the config tree v in which every string value
+// which is an ENC[...] value or holds ${env:...} or ${file:...} references is
+// replaced. v itself is left untouched.
+func (r *secretResolver) resolve(path []string, v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		s, err := r.resolveString(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+		}
+		return s, nil
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			item, err := r.resolve(append(path, k), item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = item
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(val))
+		for i, item := range val {
+			item, err := r.resolve(append(path, fmt.Sprint(i)), item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = item
+		}
+		return resolved, nil
+	}
+
+	return v, nil
+}
@@ -0,0 +1,172 @@
+package gomodule
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecryptValue(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		key := bytes.Repeat([]byte{1}, size)
+		enc, err := encryptValue(key, "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(enc, encPrefix) || !strings.HasSuffix(enc, encSuffix) || strings.Contains(enc, "hunter2") {
+			t.Fatalf("got %s", enc)
+		}
+
+		plain, err := decryptValue(key, enc)
+		if err != nil || plain != "hunter2" {
+			t.Fatalf("got %q, %v", plain, err)
+		}
+	}
+}
+
+func TestDecryptValueErrors(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	enc, err := encryptValue(key, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(enc, encPrefix), encSuffix))
+
+	tests := []struct {
+		name  string
+		key   []byte
+		value string
+		err   string
+	}{
+		{name: "wrong key", key: bytes.Repeat([]byte{2}, 32), value: enc, err: "decrypt value error"},
+		{name: "truncated", key: key, value: encPrefix + base64.StdEncoding.EncodeToString(sealed[:len(sealed)-1]) + encSuffix, err: "decrypt value error"},
+		{name: "too short", key: key, value: encPrefix + base64.StdEncoding.EncodeToString(sealed[:4]) + encSuffix, err: "too short"},
+		{name: "not base64", key: key, value: "ENC[not base64!]", err: "invalid encrypted value"},
+		{name: "bad key size", key: []byte("short"), value: enc, err: "invalid key size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptValue(tt.key, tt.value); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadSecretKey(t *testing.T) {
+	dir := t.TempDir()
+	raw := bytes.Repeat([]byte{'k'}, 16)
+	files := map[string][]byte{
+		"raw":    raw,
+		"base64": []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)) + "\n"),
+		"bad":    []byte("too short"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &configModule{}
+	if _, err := c.loadSecretKey(); err == nil {
+		t.Fatal("loaded a key without --cfg.key")
+	}
+
+	c.flags.KeyFile = filepath.Join(dir, "raw")
+	if key, err := c.loadSecretKey(); err != nil || !bytes.Equal(key, raw) {
+		t.Fatalf("got %q, %v", key, err)
+	}
+
+	c.flags.KeyFile = filepath.Join(dir, "base64")
+	if key, err := c.loadSecretKey(); err != nil || !bytes.Equal(key, bytes.Repeat([]byte{3}, 32)) {
+		t.Fatalf("got %q, %v", key, err)
+	}
+
+	c.flags.KeyFile = filepath.Join(dir, "bad")
+	if _, err := c.loadSecretKey(); err == nil {
+		t.Fatal("loaded an invalid key")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	secretFile := filepath.Join(dir, "secret")
+	key := bytes.Repeat([]byte{1}, 32)
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := encryptValue(key, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMODULE_TEST_SECRET", "from-env")
+
+	c := &configModule{}
+	c.flags.KeyFile = keyFile
+	r := &secretResolver{c: c}
+
+	tree := map[string]interface{}{
+		"db": map[string]interface{}{
+			"dsn":      "user:${env:GOMODULE_TEST_SECRET}@host",
+			"password": enc,
+			"token":    "${file:" + secretFile + "}",
+			"hosts":    []interface{}{"${env:GOMODULE_TEST_SECRET}", 1},
+		},
+		"port": 80,
+	}
+
+	resolved, err := r.resolve(nil, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"db": map[string]interface{}{
+			"dsn":      "user:from-env@host",
+			"password": "hunter2",
+			"token":    "s3cret",
+			"hosts":    []interface{}{"from-env", 1},
+		},
+		"port": 80,
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Fatalf("got %v", resolved)
+	}
+
+	// the tree itself keeps the references
+	if hosts := tree["db"].(map[string]interface{})["hosts"].([]interface{}); hosts[0] != "${env:GOMODULE_TEST_SECRET}" {
+		t.Fatalf("tree resolved in place, %v", tree)
+	}
+}
+
+func TestResolveSecretErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		err   string
+	}{
+		{name: "missing env", value: map[string]interface{}{"a": "${env:GOMODULE_TEST_UNSET}"}, err: "a: env GOMODULE_TEST_UNSET not set"},
+		{name: "missing file", value: map[string]interface{}{"a": []interface{}{"${file:/nonexistent/secret}"}}, err: "a.0: read secret file error"},
+		{name: "no key", value: map[string]interface{}{"a": "ENC[AAAA]"}, err: "a: no key file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &secretResolver{c: &configModule{}}
+			if _, err := r.resolve(nil, tt.value); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gogf/gf/os/gfile"
 	"github.com/kardianos/service"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -34,7 +35,7 @@ func (s *servctl) Start(ss service.Service) error {
 
 func (s *servctl) Stop(ss service.Service) error {
 	s.cancel()
-	return nil
+	return s.m.Wait()
 }
 
 func (s *servctl) Run(ctx context.Context) {
@@ -124,6 +125,8 @@ func (s *servctl) Run(ctx context.Context) {
 		svc.Run()
 	} else {
 		s.m.run()
-		s.m.Wait()
+		if e := s.m.Wait(); e != nil {
+			logrus.WithField("module", "servctl").Error(e)
+		}
 	}
 }
@@ -0,0 +1,137 @@
+package gomodule
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Settings holds a module's settings of type T, usually a struct with
+// mapstructure tags. Return a *Settings[T] from InitModule and the config
+// module fills it copy-on-write: every reload unmarshals into a fresh T and
+// swaps it in atomically, so Load is safe from any goroutine and the value it
+// returns is never modified afterwards. The zero value holds the zero T.
+type Settings[T any] struct {
+	value       atomic.Value
+	mtx         sync.Mutex
+	subscribers []*settingsSubscriber[T]
+}
+
+type settingsSubscriber[T any] struct {
+	fn func(old, new T)
+}
+
+// settingsHolder lets the config module fill a Settings[T] without knowing T.
+type settingsHolder interface {
+	settingsType() reflect.Type
+	loadSettings() interface{}
+	storeSettings(v interface{}) func()
+}
+
+func NewSettings[T any](initial T) *Settings[T] {
+	s := &Settings[T]{}
+	s.value.Store(&initial)
+	return s
+}
+
+// Load returns the current settings.
+func (s *Settings[T]) Load() T {
+	if p, ok := s.value.Load().(*T); ok {
+		return *p
+	}
+
+	var zero T
+	return zero
+}
+
+// Subscribe registers fn to be called with the previous and new settings
+// each time a reload changes them. fn runs on the reloading goroutine once
+// every module's settings are swapped in, before the modules' ConfigChanged,
+// and may read or change the config. The returned function removes fn.
+func (s *Settings[T]) Subscribe(fn func(old, new T)) func() {
+	sub := &settingsSubscriber[T]{fn: fn}
+
+	s.mtx.Lock()
+	s.subscribers = append(s.subscribers, sub)
+	s.mtx.Unlock()
+
+	return func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		for i, ss := range s.subscribers {
+			if ss == sub {
+				s.subscribers = append(s.subscribers[:i:i], s.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (s *Settings[T]) settingsType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (s *Settings[T]) loadSettings() interface{} {
+	return s.Load()
+}
+
+// storeSettings swaps in v, a *T freshly unmarshalled by the config module,
+// and returns the function notifying the subscribers.
+func (s *Settings[T]) storeSettings(v interface{}) func() {
+	p := v.(*T)
+	old := s.Load()
+	s.value.Store(p)
+
+	return func() {
+		s.mtx.Lock()
+		subscribers := s.subscribers
+		s.mtx.Unlock()
+
+		for _, sub := range subscribers {
+			sub.fn(old, *p)
+		}
+	}
+}
+
+// settingsType returns the type a module's settings are unmarshalled into.
+func settingsType(settings interface{}) reflect.Type {
+	if h, ok := settings.(settingsHolder); ok {
+		return h.settingsType()
+	}
+
+	t := reflect.TypeOf(settings)
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+
+	return t
+}
+
+// currentSettings returns a copy of the current value of settings.
+func currentSettings(settings interface{}) reflect.Value {
+	if h, ok := settings.(settingsHolder); ok {
+		v := reflect.New(h.settingsType()).Elem()
+		if cur := h.loadSettings(); cur != nil {
+			v.Set(reflect.ValueOf(cur))
+		}
+		return v
+	}
+
+	v := reflect.ValueOf(settings).Elem()
+	cur := reflect.New(v.Type()).Elem()
+	cur.Set(v)
+	return cur
+}
+
+// storeSettings replaces the value of settings with value, a pointer to a
+// freshly staged value, and returns the function notifying the subscribers
+// of settings, if any.
+func storeSettings(settings interface{}, value reflect.Value) func() {
+	if h, ok := settings.(settingsHolder); ok {
+		return h.storeSettings(value.Interface())
+	}
+
+	reflect.ValueOf(settings).Elem().Set(value.Elem())
+	return nil
+}
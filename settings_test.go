@@ -0,0 +1,134 @@
+package gomodule
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSettingsReloadRacesLoad(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: n0\n  port: 1\n")
+
+	var settings Settings[testSettings]
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				// name and port are always written together
+				s := settings.Load()
+				if s.Name != fmt.Sprintf("n%d", s.Port-1) {
+					t.Errorf("torn settings %+v", s)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 50; i++ {
+		writeTestConfig(t, c, fmt.Sprintf("tmod:\n  name: n%d\n  port: %d\n", i, i+1))
+		if err := c.reload("test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if s := settings.Load(); s.Port != 51 {
+		t.Fatalf("got settings %+v", s)
+	}
+}
+
+func TestSettingsSubscribe(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  name: a\n")
+
+	var settings Settings[testSettings]
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	unsubscribe := settings.Subscribe(func(old, new testSettings) {
+		got = append(got, old.Name+">"+new.Name)
+	})
+
+	for _, name := range []string{"b", "b", "c"} {
+		if name == "c" {
+			unsubscribe()
+		}
+
+		writeTestConfig(t, c, "tmod:\n  name: "+name+"\n")
+		if err := c.reload("test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 1 || got[0] != "a>b" || settings.Load().Name != "c" {
+		t.Fatalf("got %v, settings %+v", got, settings.Load())
+	}
+}
+
+func TestSettingsNotifiedInOrder(t *testing.T) {
+	c := newTestConfig(t, "tmod:\n  port: 1\n")
+
+	var settings Settings[testSettings]
+	c.m.modules = append(c.m.modules, &ModuleInfo{module: &testModule{}, settings: &settings, name: "tmod"})
+	if err := c.reload("startup"); err != nil {
+		t.Fatal(err)
+	}
+
+	var mtx sync.Mutex
+	running := false
+	last := 1
+	settings.Subscribe(func(old, new testSettings) {
+		mtx.Lock()
+		if running || old.Port != last {
+			t.Errorf("got %d>%d after %d", old.Port, new.Port, last)
+		}
+		running, last = true, new.Port
+		mtx.Unlock()
+
+		// a callback may set overrides, its notification follows this one
+		if new.Port == 20 {
+			if err := c.Set("tmod.name", "x"); err != nil {
+				t.Error(err)
+			}
+		}
+
+		mtx.Lock()
+		running = false
+		mtx.Unlock()
+	})
+
+	noDeadlock(t, func() {
+		var wg sync.WaitGroup
+		for i := 2; i <= 40; i++ {
+			wg.Add(1)
+			go func(port int) {
+				defer wg.Done()
+				if err := c.Set("tmod.port", port); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	if s := settings.Load(); s.Port != last {
+		t.Fatalf("got settings %+v, last notified %d", s, last)
+	}
+}
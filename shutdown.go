@@ -0,0 +1,154 @@
+package gomodule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultModuleStopTimeout = 10 * time.Second
+)
+
+// Stopper is implemented by modules which need to release resources or flush
+// pending work before the process exits. ModuleStop is called after the
+// manager context has been cancelled, in reverse initialization order, and
+// ctx expires when the module's stop deadline passes.
+type Stopper interface {
+	ModuleStop(ctx context.Context) error
+}
+
+type StopFailure struct {
+	Name string
+	Err  error
+}
+
+// ShutdownError reports the modules which failed to stop, or whose
+// ModuleRun did not return, before their deadline. Cause is the fatal module
+// error which stopped the manager, if any, and is what Unwrap returns.
+type ShutdownError struct {
+	Failures []*StopFailure
+	Cause    error
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Name, f.Err))
+	}
+
+	if e.Cause != nil {
+		return fmt.Sprintf("%s; shutdown failed, %s", e.Cause, strings.Join(msgs, "; "))
+	}
+
+	return fmt.Sprintf("shutdown failed, %s", strings.Join(msgs, "; "))
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Cause
+}
+
+func (m *Manager) SetShutdownTimeout(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.shutdownTimeout = d
+}
+
+func (m *Manager) SetModuleStopTimeout(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.moduleStopTimeout = d
+}
+
+func (m *Manager) shutdown() error {
+	m.shutdownOnce.Do(func() {
+		m.shutdownErr = m.stopModules()
+	})
+
+	return m.shutdownErr
+}
+
+func (m *Manager) stopModules() error {
+	m.lock.RLock()
+	timeout := m.shutdownTimeout
+	modules := m.modules
+	m.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	failures := make([]*StopFailure, 0)
+	for i := len(modules) - 1; i >= 0; i-- {
+		if err := m.stopModule(ctx, modules[i]); err != nil {
+			failures = append(failures, &StopFailure{
+				Name: modules[i].name,
+				Err:  err,
+			})
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ShutdownError{Failures: failures}
+	}
+
+	return nil
+}
+
+func (m *Manager) stopModule(ctx context.Context, mi *ModuleInfo) error {
+	start := time.Now()
+	m.emit(Event{Module: mi.name, Phase: PhaseStopping, Time: start})
+
+	err := m.waitModuleStop(ctx, mi)
+	m.emit(Event{Module: mi.name, Phase: PhaseStopped, Time: start, Duration: time.Since(start), Err: err})
+
+	return err
+}
+
+func (m *Manager) waitModuleStop(ctx context.Context, mi *ModuleInfo) error {
+	m.lock.RLock()
+	timeout := m.moduleStopTimeout
+	done := mi.done
+	m.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if s, ok := mi.module.(Stopper); ok {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- s.ModuleStop(ctx)
+		}()
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("module stop timeout, %s", ctx.Err())
+		}
+	}
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("module run not finished, %s", ctx.Err())
+	}
+}
+
+func SetShutdownTimeout(d time.Duration) {
+	defaultmanager.SetShutdownTimeout(d)
+}
+
+func SetModuleStopTimeout(d time.Duration) {
+	defaultmanager.SetModuleStopTimeout(d)
+}
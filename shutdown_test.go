@@ -0,0 +1,70 @@
+package gomodule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// runModule runs run as its ModuleRunE.
+type runModule struct {
+	DefaultModule
+	run func() error
+}
+
+func (r *runModule) Type() interface{} {
+	return r
+}
+
+func (r *runModule) ModuleRunE() error {
+	return r.run()
+}
+
+// newTestManager returns a manager of the modules, named in order, with short
+// stop timeouts, ready to run.
+func newTestManager(t *testing.T, modules map[string]IModule, names ...string) *Manager {
+	t.Helper()
+
+	m := NewManager()
+	m.shutdownTimeout = time.Second
+	m.moduleStopTimeout = 100 * time.Millisecond
+	for _, name := range names {
+		m.modules = append(m.modules, &ModuleInfo{module: modules[name], name: name})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	return m
+}
+
+func TestWaitReportsFatalAndShutdownErrors(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	m := newTestManager(t, map[string]IModule{
+		"stuck": &runModule{run: func() error {
+			<-release
+			return nil
+		}},
+		"bad": &runModule{run: func() error {
+			panic("boom")
+		}},
+	}, "stuck", "bad")
+	m.SetRestartPolicy("bad", RestartPolicy{Mode: FailManager})
+	m.run()
+
+	err := m.Wait()
+
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Module != "bad" {
+		t.Fatalf("got error %v, want the panic", err)
+	}
+
+	var se *ShutdownError
+	if !errors.As(err, &se) || len(se.Failures) != 1 || se.Failures[0].Name != "stuck" {
+		t.Fatalf("got error %v, want the shutdown failure of stuck", err)
+	}
+}
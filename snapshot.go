@@ -0,0 +1,166 @@
+package gomodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type ModuleState string
+
+const (
+	StateRegistered  ModuleState = "registered"
+	StateInitialized ModuleState = "initialized"
+	StateRunning     ModuleState = "running"
+	StateFailed      ModuleState = "failed"
+	StateRestarting  ModuleState = "restarting"
+	StateExited      ModuleState = "exited"
+	StateStopping    ModuleState = "stopping"
+	StateStopped     ModuleState = "stopped"
+)
+
+var phaseStates = map[Phase]ModuleState{
+	PhaseInitialized:        StateInitialized,
+	PhaseCommandsRegistered: StateInitialized,
+	PhasePreRun:             StateInitialized,
+	PhaseRunning:            StateRunning,
+	PhaseFailed:             StateFailed,
+	PhaseRestarting:         StateRestarting,
+	PhaseExited:             StateExited,
+	PhaseStopping:           StateStopping,
+	PhaseStopped:            StateStopped,
+}
+
+// ModuleSnapshot is a read-only view of a registered module.
+type ModuleSnapshot struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	Default   bool        `json:"default"`
+	State     ModuleState `json:"state"`
+	StartTime *time.Time  `json:"startTime,omitempty"`
+	LastError string      `json:"lastError,omitempty"`
+	Restarts  int         `json:"restarts"`
+	Features  []string    `json:"features"`
+	Commands  []string    `json:"commands"`
+}
+
+// track updates the state of the module an event belongs to.
+func (m *Manager) track(e Event) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, mi := range m.moduleInfos() {
+		if mi.name != e.Module {
+			continue
+		}
+
+		if state, ok := phaseStates[e.Phase]; ok {
+			mi.state = state
+		}
+
+		switch e.Phase {
+		case PhaseRunning:
+			if mi.startTime.IsZero() {
+				mi.startTime = e.Time
+			}
+		case PhaseRestarting:
+			mi.restarts++
+		}
+
+		if e.Err != nil {
+			mi.lastErr = e.Err
+		}
+		return
+	}
+}
+
+func featureName(f Feature) string {
+	t := reflect.TypeOf(f.Type())
+	if t == nil {
+		return ""
+	}
+
+	if t.Kind() == reflect.Ptr && (t.Elem().Kind() == reflect.Interface || t.Elem().Kind() == reflect.Ptr) {
+		return t.Elem().String()
+	}
+
+	return t.String()
+}
+
+// Modules returns a snapshot of every registered module, default modules
+// first, in initialization order once the manager has launched.
+func (m *Manager) Modules() []ModuleSnapshot {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	infos := m.moduleInfos()
+	snapshots := make([]ModuleSnapshot, 0, len(infos))
+	for _, mi := range infos {
+		ms := ModuleSnapshot{
+			Name:     mi.name,
+			Type:     reflect.TypeOf(mi.module).String(),
+			Default:  mi.isDefault,
+			State:    mi.state,
+			Restarts: mi.restarts,
+			Features: make([]string, 0),
+			Commands: make([]string, 0),
+		}
+
+		if !mi.startTime.IsZero() {
+			startTime := mi.startTime
+			ms.StartTime = &startTime
+		}
+
+		if mi.lastErr != nil {
+			ms.LastError = mi.lastErr.Error()
+		}
+
+		if name := featureName(mi.module); name != "" {
+			ms.Features = append(ms.Features, name)
+		}
+
+		for _, cmd := range mi.cmds {
+			ms.Commands = append(ms.Commands, cmd.Name())
+		}
+
+		snapshots = append(snapshots, ms)
+	}
+
+	return snapshots
+}
+
+func (m *Manager) modulesCommand() *cobra.Command {
+	asJSON := false
+	cmd := &cobra.Command{
+		Use:   "modules",
+		Short: "list registered modules",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			snapshots := m.Modules()
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(snapshots)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tTYPE\tDEFAULT\tSTATE\tFEATURES\tCOMMANDS\tLAST ERROR")
+			for _, ms := range snapshots {
+				fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%v\t%v\t%s\n",
+					ms.Name, ms.Type, ms.Default, ms.State, ms.Features, ms.Commands, ms.LastError)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print modules as json")
+
+	return cmd
+}
+
+func Modules() []ModuleSnapshot {
+	return defaultmanager.Modules()
+}
@@ -0,0 +1,495 @@
+package gomodule
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Kinds of config sources, in merge order.
+const (
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceConfD   = "conf.d"
+	SourceEtcd    = "etcd"
+	SourceConsul  = "consul"
+	SourceRemote  = "remote"
+	SourceEnv     = "env"
+	SourceFlags   = "flags"
+)
+
+const (
+	defaultConfDir    = "conf.d"
+	defaultConfigName = "config"
+)
+
+// defaultSearchPaths are the directories searched for the config file when
+// neither --cfg.local nor --cfg.path is given.
+var defaultSearchPaths = []string{".", "./config"}
+
+// configExts are the config file extensions discovery probes, in priority
+// order.
+var configExts = []string{"yml", "yaml", "json", "toml", "hcl", "tfvars", "ini", "properties", "props", "prop", "env", "dotenv"}
+
+// sniffTypes are the formats tried, in order, on a config file whose
+// extension does not name its format.
+var sniffTypes = []string{"json", "toml", "yaml", "hcl", "ini"}
+
+// ConfigSource is one layer of the merged config. Layers are deep merged in
+// the order returned by Sources, every layer overriding the keys it sets in
+// the layers before it:
+//
+//  1. file: every --cfg.local file in order, or the discovered config file
+//  2. profile: config.<profile>.<ext> beside the first file, --cfg.profile
+//  3. conf.d: every config file of --cfg.dir, ./conf.d beside the first file
+//     by default, in lexical order
+//  4. etcd: the --cfg.etcd key
+//  5. consul: the --cfg.consul key
+//  6. remote: the --cfg.remote document
+//  7. env: <PREFIX>_<MODULE>_<FIELD> environment variables
+//  8. flags: --cfg.set values
+//  9. override: values set at runtime with Set and Override
+type ConfigSource struct {
+	Kind     string `json:"kind"`
+	Location string `json:"location"`
+}
+
+func (s ConfigSource) String() string {
+	return s.Kind + ":" + s.Location
+}
+
+// configLayer is the config tree read from a source.
+type configLayer struct {
+	source ConfigSource
+	values map[string]interface{}
+}
+
+// resolveLocalFiles returns the absolute paths of the --cfg.local files, or
+// of the discovered default config file. The default file is optional when
+// config is loaded from etcd, consul or the remote api.
+func (c *configModule) resolveLocalFiles() ([]string, error) {
+	files := make([]string, 0, len(c.flags.LocalFiles))
+	for _, file := range c.flags.LocalFiles {
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("config file not found: %s", file)
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		file := c.discoverConfigFile()
+		if file == "" {
+			if c.hasRemoteSource() {
+				c.Logger().Debug("default config file not found, use remote config only")
+				return nil, nil
+			}
+			return nil, fmt.Errorf("config file %s.{%s} not found in %s", c.configName(), strings.Join(configExts, ","), strings.Join(c.searchPaths(), ", "))
+		}
+
+		files = append(files, file)
+	}
+
+	for i, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("config file path error, %s", err)
+		}
+		files[i] = abs
+	}
+
+	return files, nil
+}
+
+func (c *configModule) configName() string {
+	if c.flags.Name != "" {
+		return c.flags.Name
+	}
+
+	return defaultConfigName
+}
+
+func (c *configModule) searchPaths() []string {
+	if len(c.flags.SearchPaths) > 0 {
+		return c.flags.SearchPaths
+	}
+
+	return defaultSearchPaths
+}
+
+// discoverConfigFile looks for <name>.<ext> in every search path in order,
+// probing the extensions in the order of configExts, then for <name>
+// without extension, whose format is sniffed when read.
+func (c *configModule) discoverConfigFile() string {
+	name := c.configName()
+	for _, dir := range c.searchPaths() {
+		candidates := make([]string, 0, len(configExts)+1)
+		for _, ext := range configExts {
+			candidates = append(candidates, filepath.Join(dir, name+"."+ext))
+		}
+		candidates = append(candidates, filepath.Join(dir, name))
+
+		for _, file := range candidates {
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				c.Logger().Debug("found config file: ", file)
+				return file
+			}
+		}
+	}
+
+	return ""
+}
+
+func (c *configModule) hasRemoteSource() bool {
+	return c.flags.Etcd != "" || c.flags.Consul != "" || c.flags.RemoteFile != ""
+}
+
+// profileFile returns config.<profile>.yml for the base file config.yml.
+func (c *configModule) profileFile() string {
+	if c.flags.Profile == "" || len(c.localFiles) == 0 {
+		return ""
+	}
+
+	base := c.localFiles[0]
+	ext := filepath.Ext(base)
+
+	return strings.TrimSuffix(base, ext) + "." + c.flags.Profile + ext
+}
+
+// confDir returns the conf.d directory, or "" when there is none.
+func (c *configModule) confDir() string {
+	if c.flags.ConfDir != "" {
+		dir, err := filepath.Abs(c.flags.ConfDir)
+		if err != nil {
+			return c.flags.ConfDir
+		}
+		return dir
+	}
+
+	if len(c.localFiles) == 0 {
+		return ""
+	}
+
+	dir := filepath.Join(filepath.Dir(c.localFiles[0]), defaultConfDir)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return ""
+	}
+
+	return dir
+}
+
+// confDFiles lists the config files of the conf.d directory in lexical order.
+func (c *configModule) confDFiles() ([]string, error) {
+	dir := c.confDir()
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read config dir error, %s", err)
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && isConfigExt(filepath.Ext(e.Name())) {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// sourceList returns the configured sources in merge order. The caller must
+// hold c.mtx.
+func (c *configModule) sourceList() ([]ConfigSource, error) {
+	sources := make([]ConfigSource, 0)
+	for _, file := range c.localFiles {
+		sources = append(sources, ConfigSource{Kind: SourceFile, Location: file})
+	}
+
+	if profile := c.profileFile(); profile != "" {
+		sources = append(sources, ConfigSource{Kind: SourceProfile, Location: profile})
+	}
+
+	files, err := c.confDFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		sources = append(sources, ConfigSource{Kind: SourceConfD, Location: file})
+	}
+
+	if c.flags.Etcd != "" {
+		sources = append(sources, ConfigSource{Kind: SourceEtcd, Location: redactURL(c.flags.Etcd)})
+	}
+
+	if c.flags.Consul != "" {
+		sources = append(sources, ConfigSource{Kind: SourceConsul, Location: redactURL(c.flags.Consul)})
+	}
+
+	if c.flags.RemoteFile != "" {
+		sources = append(sources, ConfigSource{Kind: SourceRemote, Location: redactURL(c.flags.RemoteFile)})
+	}
+
+	sources = append(sources,
+		ConfigSource{Kind: SourceEnv, Location: c.flags.EnvPrefix + "_*"},
+		ConfigSource{Kind: SourceFlags, Location: "--cfg.set"},
+	)
+
+	if len(c.overrides) > 0 {
+		sources = append(sources, ConfigSource{Kind: SourceOverride, Location: "api"})
+	}
+
+	return sources, nil
+}
+
+// redactURL returns the url of a source without the credentials it may
+// carry, the user info and the token query parameter.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	u.User = nil
+	q := u.Query()
+	q.Del("token")
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// Sources returns the config sources in merge order, later sources
+// overriding earlier ones.
+func (c *configModule) Sources() ([]ConfigSource, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.sourceList()
+}
+
+// readConfigFile reads a config file in the format its extension names, or
+// the format sniffed from its content.
+func readConfigFile(file string) (map[string]interface{}, error) {
+	if decode := configDecoder(strings.TrimPrefix(filepath.Ext(file), ".")); decode != nil {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read config file error, %s", err)
+		}
+
+		return decode(data)
+	}
+
+	if !isConfigExt(filepath.Ext(file)) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read config file error, %s", err)
+		}
+
+		configType := sniffConfigType(data)
+		if configType == "" {
+			return nil, fmt.Errorf("read config file error, unknown format of %s", file)
+		}
+
+		return readConfigData(data, configType)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read config file error, %s", err)
+	}
+
+	return v.AllSettings(), nil
+}
+
+func isConfigExt(ext string) bool {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	for _, e := range configExts {
+		if e == ext {
+			return true
+		}
+	}
+
+	return ext != "" && configDecoder(ext) != nil
+}
+
+// sniffConfigType returns the first format of sniffTypes data parses as a
+// non empty config tree in, or "".
+func sniffConfigType(data []byte) string {
+	for _, configType := range sniffTypes {
+		if values, err := readConfigData(data, configType); err == nil && len(values) > 0 {
+			return configType
+		}
+	}
+
+	return ""
+}
+
+// readConfigData parses data with the decoder registered for configType, or
+// with viper.
+func readConfigData(data []byte, configType string) (map[string]interface{}, error) {
+	if decode := configDecoder(configType); decode != nil {
+		return decode(data)
+	}
+
+	v := viper.New()
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	return v.AllSettings(), nil
+}
+
+// readLayers reads every source into its own config tree, in merge order,
+// and resolves the secret references of each. The caller must hold c.mtx.
+func (c *configModule) readLayers() ([]configLayer, error) {
+	sources, err := c.sourceList()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := &secretResolver{c: c}
+	layers := make([]configLayer, 0, len(sources))
+	for _, src := range sources {
+		var values map[string]interface{}
+		switch src.Kind {
+		case SourceFile, SourceProfile, SourceConfD:
+			values, err = readConfigFile(src.Location)
+		case SourceEtcd, SourceConsul:
+			for _, s := range c.kvSources {
+				if s.provider == src.Kind {
+					configType := s.configType
+					if configType == "" {
+						configType = sniffConfigType(s.data)
+					}
+					values, err = readConfigData(s.data, configType)
+					if err != nil {
+						err = fmt.Errorf("read config from %s error, %s", s.provider, err)
+					}
+				}
+			}
+		case SourceRemote:
+			if c.remote != nil {
+				values, err = readConfigData(c.remote.data, c.remote.configType)
+				if err != nil {
+					err = fmt.Errorf("read config error, %s", err)
+				}
+			}
+		case SourceEnv:
+			values = c.envLayer()
+		case SourceFlags:
+			values, err = setOverlay(c.flags.Set)
+		case SourceOverride:
+			values = c.overrides
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		resolved, err := secrets.resolve(nil, values)
+		if err != nil {
+			return nil, fmt.Errorf("resolve secrets of %s error, %s", src, err)
+		}
+		values, _ = resolved.(map[string]interface{})
+
+		layers = append(layers, configLayer{source: src, values: values})
+	}
+
+	return layers, nil
+}
+
+// watchLocalFiles reloads the config whenever a local config file is written
+// or replaced, or a file of the conf.d directory changes. Directories are
+// watched rather than files, so editors which replace the file and symlink
+// swaps are noticed too. A half written file fails to parse and is rejected
+// by the reload.
+func (c *configModule) watchLocalFiles() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]string)
+	dirs := make(map[string]bool)
+	add := func(file string) {
+		file = filepath.Clean(file)
+		files[file], _ = filepath.EvalSymlinks(file)
+		dirs[filepath.Dir(file)] = true
+	}
+
+	for _, file := range c.localFiles {
+		add(file)
+	}
+
+	if profile := c.profileFile(); profile != "" {
+		add(profile)
+	}
+
+	confDir := c.confDir()
+	if confDir != "" {
+		dirs[confDir] = true
+	}
+
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if e.Op == fsnotify.Chmod {
+					continue
+				}
+
+				name := filepath.Clean(e.Name)
+				changed := filepath.Dir(name) == confDir && isConfigExt(filepath.Ext(name))
+				if _, ok := files[name]; ok && e.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					changed = true
+				}
+
+				for file, realFile := range files {
+					if current, _ := filepath.EvalSymlinks(file); current != "" && current != realFile {
+						files[file] = current
+						changed = true
+					}
+				}
+
+				if changed {
+					c.Logger().Debug("config file changed: ", e.Name)
+					c.reload("local")
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.Logger().Error("watch config file error, ", err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package gomodule
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// RunnerE is implemented by modules whose run loop can fail. The manager calls
+// ModuleRunE instead of ModuleRun for such modules and applies the module's
+// restart policy to the returned error.
+type RunnerE interface {
+	ModuleRunE() error
+}
+
+const defaultRestartBackoff = time.Second
+
+type RestartMode int
+
+const (
+	// RestartNever logs the failure and leaves the module stopped.
+	RestartNever RestartMode = iota
+	// RestartOnFailure runs the module again after a backoff delay.
+	RestartOnFailure
+	// FailManager stops every module and makes Run return the error.
+	FailManager
+)
+
+func (r RestartMode) String() string {
+	switch r {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case FailManager:
+		return "fail-manager"
+	default:
+		return fmt.Sprintf("RestartMode(%d)", int(r))
+	}
+}
+
+// RestartPolicy controls what the manager does when a module's run returns an
+// error or panics. With RestartOnFailure the backoff starts at Backoff (one
+// second if unset) and doubles after every restart up to MaxBackoff; once
+// MaxRestarts (if non zero) is exceeded the failure is escalated to the
+// manager.
+type RestartPolicy struct {
+	Mode        RestartMode
+	MaxRestarts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Supervised is implemented by modules which choose their own restart policy.
+// A policy set with Manager.SetRestartPolicy takes precedence.
+type Supervised interface {
+	RestartPolicy() RestartPolicy
+}
+
+// PanicError wraps a value recovered from a panicking module.
+type PanicError struct {
+	Module string
+	Value  interface{}
+	Stack  []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("module[%s] panic: %v", e.Module, e.Value)
+}
+
+func (m *Manager) SetRestartPolicy(name string, policy RestartPolicy) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.restartPolicies == nil {
+		m.restartPolicies = make(map[string]RestartPolicy)
+	}
+
+	m.restartPolicies[name] = policy
+}
+
+func (m *Manager) restartPolicy(mi *ModuleInfo) RestartPolicy {
+	m.lock.RLock()
+	policy, ok := m.restartPolicies[mi.name]
+	m.lock.RUnlock()
+
+	if ok {
+		return policy
+	}
+
+	if s, ok := mi.module.(Supervised); ok {
+		return s.RestartPolicy()
+	}
+
+	return RestartPolicy{Mode: RestartNever}
+}
+
+// fail records the first fatal module error and cancels the manager.
+func (m *Manager) fail(err error) {
+	m.lock.Lock()
+	if m.fatalErr == nil {
+		m.fatalErr = err
+	}
+	m.lock.Unlock()
+
+	m.cancel()
+}
+
+func (m *Manager) runModuleOnce(mi *ModuleInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Module: mi.name,
+				Value:  r,
+				Stack:  debug.Stack(),
+			}
+		}
+	}()
+
+	if r, ok := mi.module.(RunnerE); ok {
+		return r.ModuleRunE()
+	}
+
+	mi.module.ModuleRun()
+
+	return nil
+}
+
+func (m *Manager) superviseModule(mi *ModuleInfo) {
+	defer m.wg.Done()
+	defer close(mi.done)
+
+	policy := m.restartPolicy(mi)
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = defaultRestartBackoff
+	}
+
+	for restarts := 0; ; restarts++ {
+		start := time.Now()
+		m.emit(Event{Module: mi.name, Phase: PhaseRunning, Time: start})
+		err := m.runModuleOnce(mi)
+		if err == nil {
+			m.emit(Event{Module: mi.name, Phase: PhaseExited, Duration: time.Since(start)})
+			return
+		}
+
+		if m.ctx.Err() != nil {
+			m.logger.Warnf("module[%s] exited with error while stopping, %s", mi.name, err)
+			m.emit(Event{Module: mi.name, Phase: PhaseExited, Duration: time.Since(start), Err: err})
+			return
+		}
+
+		m.logger.Errorf("module[%s] failed, %s", mi.name, err)
+		m.emit(Event{Module: mi.name, Phase: PhaseFailed, Duration: time.Since(start), Err: err})
+
+		switch policy.Mode {
+		case FailManager:
+			m.fail(fmt.Errorf("module[%s] failed, %w", mi.name, err))
+			return
+		case RestartOnFailure:
+			if policy.MaxRestarts > 0 && restarts >= policy.MaxRestarts {
+				m.fail(fmt.Errorf("module[%s] failed after %d restarts, %w", mi.name, restarts, err))
+				return
+			}
+		default:
+			return
+		}
+
+		m.logger.Infof("restart module[%s] in %s", mi.name, backoff)
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		m.emit(Event{Module: mi.name, Phase: PhaseRestarting})
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func SetRestartPolicy(name string, policy RestartPolicy) {
+	defaultmanager.SetRestartPolicy(name, policy)
+}
@@ -0,0 +1,137 @@
+package gomodule
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// phaseRecorder records the phases of the events of a module.
+type phaseRecorder struct {
+	mtx    sync.Mutex
+	phases []Phase
+	errs   []error
+}
+
+func (r *phaseRecorder) observe(e Event) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.phases = append(r.phases, e.Phase)
+	r.errs = append(r.errs, e.Err)
+}
+
+func (r *phaseRecorder) count(phase Phase) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	n := 0
+	for _, p := range r.phases {
+		if p == phase {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestRestartOnFailureBackoff(t *testing.T) {
+	errFail := errors.New("fail")
+	var runs []time.Time
+	m := newTestManager(t, map[string]IModule{
+		"flaky": &runModule{run: func() error {
+			runs = append(runs, time.Now())
+			if len(runs) < 4 {
+				return errFail
+			}
+			return nil
+		}},
+	}, "flaky")
+	m.SetRestartPolicy("flaky", RestartPolicy{Mode: RestartOnFailure, Backoff: 20 * time.Millisecond, MaxBackoff: 40 * time.Millisecond})
+
+	var rec phaseRecorder
+	m.Subscribe(rec.observe)
+	m.run()
+
+	if err := m.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 4 || rec.count(PhaseFailed) != 3 || rec.count(PhaseRestarting) != 3 || rec.count(PhaseExited) != 1 {
+		t.Fatalf("got %d runs, phases %v", len(runs), rec.phases)
+	}
+
+	// the backoff doubles up to MaxBackoff
+	for i, want := range []time.Duration{20, 40, 40} {
+		if gap := runs[i+1].Sub(runs[i]); gap < want*time.Millisecond {
+			t.Errorf("restart %d after %s, want at least %dms", i+1, gap, want)
+		}
+	}
+}
+
+func TestMaxRestartsEscalates(t *testing.T) {
+	errFail := errors.New("fail")
+	runs := 0
+	m := newTestManager(t, map[string]IModule{
+		"bad": &runModule{run: func() error {
+			runs++
+			return errFail
+		}},
+	}, "bad")
+	m.SetRestartPolicy("bad", RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 2, Backoff: time.Millisecond})
+	m.run()
+
+	err := m.Wait()
+	if !errors.Is(err, errFail) || err.Error() != "module[bad] failed after 2 restarts, fail" {
+		t.Fatalf("got error %v", err)
+	}
+
+	if runs != 3 {
+		t.Fatalf("got %d runs", runs)
+	}
+}
+
+func TestModuleRunEError(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name   string
+		policy RestartPolicy
+		err    string
+	}{
+		{name: "never", policy: RestartPolicy{Mode: RestartNever}},
+		{name: "fail manager", policy: RestartPolicy{Mode: FailManager}, err: "module[bad] failed, fail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			m := newTestManager(t, map[string]IModule{
+				"bad": &runModule{run: func() error {
+					runs++
+					return errFail
+				}},
+			}, "bad")
+			m.SetRestartPolicy("bad", tt.policy)
+
+			var rec phaseRecorder
+			m.Subscribe(rec.observe)
+			m.run()
+
+			err := m.Wait()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+
+			if runs != 1 || rec.count(PhaseFailed) != 1 || rec.count(PhaseRestarting) != 0 {
+				t.Fatalf("got %d runs, phases %v", runs, rec.phases)
+			}
+
+			for i, p := range rec.phases {
+				if p == PhaseFailed && !errors.Is(rec.errs[i], errFail) {
+					t.Fatalf("failed event error %v", rec.errs[i])
+				}
+			}
+		})
+	}
+}
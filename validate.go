@@ -0,0 +1,279 @@
+package gomodule
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// FieldError describes a settings field which failed validation. Field is
+// the config path of the field below the module key.
+type FieldError struct {
+	Module string
+	Field  string
+	Rule   string
+	Msg    string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", e.Module, e.Field, e.Msg)
+}
+
+// ValidationError lists every field of a module's settings which failed its
+// validate tag.
+type ValidationError struct {
+	Module string
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+
+	return fmt.Sprintf("module[%s] settings invalid, %s", e.Module, strings.Join(msgs, "; "))
+}
+
+// setFromString parses s into v according to v's kind. Slices are comma
+// separated.
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		parts := strings.Split(s, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFromString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Kind())
+	}
+
+	return nil
+}
+
+// applyDefaults sets every field of the struct v points to from its default
+// tag, recursing into nested structs. section is the config tree of the
+// struct: slice defaults are only applied to keys it does not set, since
+// unmarshalling merges into a non-nil slice rather than replacing it.
+func applyDefaults(module string, v reflect.Value, section map[string]interface{}) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		// a field unmarshalling skips has no section
+		key, tagged := settingsKey(f)
+		var value interface{}
+		if tagged && key != "" {
+			value = section[treeKey(section, key)]
+		} else if tagged && section != nil {
+			value = section
+		}
+
+		fv := v.Field(i)
+		if def, ok := f.Tag.Lookup("default"); ok {
+			if value != nil && fv.Kind() == reflect.Slice {
+				continue
+			}
+
+			if err := setFromString(fv, def); err != nil {
+				return fmt.Errorf("module[%s] field %s default %q invalid, %s", module, f.Name, def, err)
+			}
+			continue
+		}
+
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			sub, _ := value.(map[string]interface{})
+			if err := applyDefaults(module, fv.Addr(), sub); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// size returns the value compared by the min and max rules: the length of
+// strings, slices and maps, or the number itself.
+func size(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// checkRule applies a single validate rule to v, returning a message when it
+// fails.
+func checkRule(v reflect.Value, rule string) (string, error) {
+	name, arg := rule, ""
+	if idx := strings.Index(rule, "="); idx >= 0 {
+		name, arg = rule[:idx], rule[idx+1:]
+	}
+
+	switch name {
+	case "required":
+		if v.IsZero() {
+			return "is required", nil
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s rule %q", name, rule)
+		}
+
+		n, ok := size(v)
+		if !ok {
+			return "", fmt.Errorf("%s rule not supported on %s", name, v.Kind())
+		}
+
+		if name == "min" && n < limit {
+			return fmt.Sprintf("must be at least %s", arg), nil
+		}
+
+		if name == "max" && n > limit {
+			return fmt.Sprintf("must be at most %s", arg), nil
+		}
+	case "oneof":
+		// strings match case insensitively, like the values they name are
+		// usually parsed
+		s := fmt.Sprint(v.Interface())
+		for _, option := range strings.Split(arg, "|") {
+			if s == option || v.Kind() == reflect.String && strings.EqualFold(s, option) {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("must be one of %s, got %q", strings.ReplaceAll(arg, "|", ", "), s), nil
+	default:
+		return "", fmt.Errorf("unknown validate rule %q", rule)
+	}
+
+	return "", nil
+}
+
+// validateSettings checks every field of the struct v points to against its
+// validate tag, e.g. `validate:"required,min=1,oneof=text|json"`.
+func validateSettings(module string, path []string, v reflect.Value) ([]*FieldError, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	errs := make([]*FieldError, 0)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key, ok := settingsKey(f)
+		if !ok {
+			continue
+		}
+
+		fieldPath := path
+		if key != "" {
+			fieldPath = append(append([]string(nil), path...), key)
+		}
+
+		fv := v.Field(i)
+		if tag := f.Tag.Get("validate"); tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				msg, err := checkRule(fv, rule)
+				if err != nil {
+					return nil, fmt.Errorf("module[%s] field %s, %s", module, strings.Join(fieldPath, "."), err)
+				}
+
+				if msg != "" {
+					errs = append(errs, &FieldError{
+						Module: module,
+						Field:  strings.Join(fieldPath, "."),
+						Rule:   rule,
+						Msg:    msg,
+					})
+					break
+				}
+			}
+		}
+
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			sub, err := validateSettings(module, fieldPath, fv.Addr())
+			if err != nil {
+				return nil, err
+			}
+			errs = append(errs, sub...)
+		}
+	}
+
+	return errs, nil
+}
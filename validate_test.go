@@ -0,0 +1,182 @@
+package gomodule
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type nestedSettings struct {
+	Host    string        `mapstructure:"host" default:"localhost" validate:"required"`
+	Timeout time.Duration `mapstructure:"timeout" default:"5s"`
+}
+
+type defaultSettings struct {
+	Name    string         `mapstructure:"name" default:"app"`
+	Port    int            `mapstructure:"port" default:"80" validate:"min=1,max=65535"`
+	Ratio   float64        `mapstructure:"ratio" default:"0.5"`
+	Debug   bool           `mapstructure:"debug" default:"true"`
+	Tags    []string       `mapstructure:"tags" default:"a,b,c" validate:"min=1"`
+	Ports   []int          `mapstructure:"ports" default:"1,2"`
+	Server  nestedSettings `mapstructure:"server"`
+	Skipped []string       `mapstructure:"-" default:"x"`
+}
+
+// stageTestSettings stages the tmod section of the yaml config.
+func stageTestSettings(t *testing.T, config string) (defaultSettings, error) {
+	t.Helper()
+
+	c := newTestConfig(t, config)
+	c.mtx.Lock()
+	v, _, err := c.buildConfig()
+	c.mtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staged, err := c.stageSettings(v, "tmod", &defaultSettings{})
+	if err != nil {
+		return defaultSettings{}, err
+	}
+
+	return staged.Elem().Interface().(defaultSettings), nil
+}
+
+func TestDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   defaultSettings
+	}{
+		{
+			name:   "no section",
+			config: "other: {}\n",
+			want: defaultSettings{Name: "app", Port: 80, Ratio: 0.5, Debug: true, Tags: []string{"a", "b", "c"}, Ports: []int{1, 2},
+				Server: nestedSettings{Host: "localhost", Timeout: 5 * time.Second}, Skipped: []string{"x"}},
+		},
+		{
+			name:   "config replaces slices",
+			config: "tmod:\n  tags: [x]\n  ports: [9]\n  debug: false\n  server:\n    timeout: 1m\n",
+			want: defaultSettings{Name: "app", Port: 80, Ratio: 0.5, Debug: false, Tags: []string{"x"}, Ports: []int{9},
+				Server: nestedSettings{Host: "localhost", Timeout: time.Minute}, Skipped: []string{"x"}},
+		},
+		{
+			name:   "keys are case insensitive",
+			config: "TMOD:\n  Tags: [y]\n  SERVER:\n    Host: h\n",
+			want: defaultSettings{Name: "app", Port: 80, Ratio: 0.5, Debug: true, Tags: []string{"y"}, Ports: []int{1, 2},
+				Server: nestedSettings{Host: "h", Timeout: 5 * time.Second}, Skipped: []string{"x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stageTestSettings(t, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidDefault(t *testing.T) {
+	var s struct {
+		Port int `mapstructure:"port" default:"eighty"`
+	}
+
+	if err := applyDefaults("tmod", reflect.ValueOf(&s), nil); err == nil || !strings.Contains(err.Error(), "default \"eighty\" invalid") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		fields []string
+	}{
+		{name: "valid", config: "tmod:\n  port: 8080\n"},
+		{name: "min", config: "tmod:\n  port: 0\n", fields: []string{"port"}},
+		{name: "max", config: "tmod:\n  port: 65536\n", fields: []string{"port"}},
+		{name: "slice min", config: "tmod:\n  tags: []\n", fields: []string{"tags"}},
+		{name: "nested required", config: "tmod:\n  server:\n    host: ''\n", fields: []string{"server.host"}},
+		{name: "every field", config: "tmod:\n  port: 0\n  tags: []\n  server:\n    host: ''\n", fields: []string{"port", "tags", "server.host"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := stageTestSettings(t, tt.config)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("got error %v", err)
+			}
+
+			fields := make([]string, 0, len(ve.Errors))
+			for _, fe := range ve.Errors {
+				fields = append(fields, fe.Field)
+			}
+
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("got invalid fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestCheckRule(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		rule  string
+		msg   string
+		err   bool
+	}{
+		{value: "", rule: "required", msg: "is required"},
+		{value: "x", rule: "required"},
+		{value: 0, rule: "required", msg: "is required"},
+		{value: "ab", rule: "min=3", msg: "must be at least 3"},
+		{value: "abc", rule: "max=2", msg: "must be at most 2"},
+		{value: 1.5, rule: "max=1.5"},
+		{value: uint(3), rule: "min=4", msg: "must be at least 4"},
+		{value: map[string]int{"a": 1}, rule: "min=1"},
+		{value: "json", rule: "oneof=text|json"},
+		{value: "JSON", rule: "oneof=text|json"},
+		{value: "xml", rule: "oneof=text|json", msg: `must be one of text, json, got "xml"`},
+		{value: 3, rule: "oneof=1|2|3"},
+		{value: 1, rule: "min=x", err: true},
+		{value: true, rule: "min=1", err: true},
+		{value: 1, rule: "email", err: true},
+	}
+
+	for _, tt := range tests {
+		msg, err := checkRule(reflect.ValueOf(tt.value), tt.rule)
+		if (err != nil) != tt.err || msg != tt.msg {
+			t.Errorf("%v %s: got %q, %v, want %q", tt.value, tt.rule, msg, err, tt.msg)
+		}
+	}
+}
+
+func TestStageSettingsWithoutConfig(t *testing.T) {
+	c := newTestConfig(t, "")
+	staged, err := c.stageSettings(viper.New(), "tmod", &defaultSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := staged.Elem().Interface().(defaultSettings); s.Port != 80 || len(s.Tags) != 3 {
+		t.Fatalf("got %+v", s)
+	}
+}